@@ -0,0 +1,617 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// A bracket picks a single winner out of a set of media through single
+// or double elimination. Entrants are padded with byes to a power of
+// two and placed so the top seeds meet as late as possible. Every match
+// is also recorded as a regular comparison.
+
+type bracketSection int
+
+const (
+	winnersBracket bracketSection = iota
+	losersBracket
+	grandFinal
+)
+
+func (b bracketSection) String() string {
+	switch b {
+	case losersBracket:
+		return "Losers Bracket"
+	case grandFinal:
+		return "Grand Final"
+	default:
+		return "Winners Bracket"
+	}
+}
+
+type Seeding int
+
+const (
+	SeedByScore Seeding = iota
+	SeedRandom
+)
+
+func ParseSeeding(s string) (Seeding, error) {
+	switch s {
+	case "", "score":
+		return SeedByScore, nil
+	case "random":
+		return SeedRandom, nil
+	}
+	return 0, fmt.Errorf("unknown seeding %q", s)
+}
+
+// bracketMatch is a match in a bracket, its slots are filled in as the
+// matches feeding them are decided.
+type bracketMatch struct {
+	Number int
+	Section bracketSection
+	Round int
+	Media [2]int64
+	Bye [2]bool
+	Decided bool
+	Winner int64
+	WinnerTo int
+	WinnerSlot int
+	LoserTo int
+	LoserSlot int
+}
+
+// ready reports whether both slots are either filled or byes
+func (m *bracketMatch) ready() bool {
+	return (m.Media[0] != 0 || m.Bye[0]) && (m.Media[1] != 0 || m.Bye[1])
+}
+
+// playable reports whether the match is waiting on a vote
+func (m *bracketMatch) playable() bool {
+	return !m.Decided && m.Media[0] != 0 && m.Media[1] != 0
+}
+
+// seedOrder returns the seeds of the slots of the first round of a
+// bracket of size entrants, pairing the best seed with the worst.
+func seedOrder(size int) []int {
+	order := []int{ 0 }
+	for n := 2; n <= size; n *= 2 {
+		next := make([]int, 0, n)
+		for _, seed := range(order) {
+			next = append(next, seed, n - 1 - seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// buildBracket lays out every match of a bracket for seeds, ordered
+// best first, and decides the matches against byes.
+func buildBracket(seeds []int64, double bool) []bracketMatch {
+	size, rounds := 2, 1
+	for size < len(seeds) {
+		size *= 2
+		rounds++
+	}
+
+	var matches []bracketMatch
+	add := func(section bracketSection, round int) int {
+		matches = append(matches, bracketMatch{
+			Number: len(matches),
+			Section: section,
+			Round: round,
+			WinnerTo: -1,
+			LoserTo: -1,
+		})
+		return len(matches) - 1
+	}
+	feedWinner := func(from, to, slot int) {
+		matches[from].WinnerTo, matches[from].WinnerSlot = to, slot
+	}
+	feedLoser := func(from, to, slot int) {
+		matches[from].LoserTo, matches[from].LoserSlot = to, slot
+	}
+
+	winners := make([][]int, rounds)
+	for r := range(winners) {
+		for p := 0; p < size >> (r + 1); p++ {
+			winners[r] = append(winners[r], add(winnersBracket, r + 1))
+		}
+	}
+	for i, seed := range(seedOrder(size)) {
+		match := &matches[winners[0][i / 2]]
+		if seed < len(seeds) {
+			match.Media[i % 2] = seeds[seed]
+		} else {
+			match.Bye[i % 2] = true
+		}
+	}
+	for r := 0; r < rounds - 1; r++ {
+		for p, n := range(winners[r]) {
+			feedWinner(n, winners[r + 1][p / 2], p % 2)
+		}
+	}
+
+	if double {
+		// losers of winners bracket round r drop into losers bracket
+		// round 2r - 2, alternating with rounds that halve the field
+		losers := make([][]int, 2 * (rounds - 1))
+		for j := range(losers) {
+			for p := 0; p < size >> (j / 2 + 2); p++ {
+				losers[j] = append(losers[j], add(losersBracket, j + 1))
+			}
+		}
+		for j, round := range(losers) {
+			for p, n := range(round) {
+				switch {
+				case j == 0:
+					feedLoser(winners[0][2 * p], n, 0)
+					feedLoser(winners[0][2 * p + 1], n, 1)
+				case j % 2 == 1:
+					// dropped losers are reversed to put off rematches
+					dropping := winners[(j + 1) / 2]
+					feedWinner(losers[j - 1][p], n, 0)
+					feedLoser(dropping[len(dropping) - 1 - p], n, 1)
+				default:
+					feedWinner(losers[j - 1][2 * p], n, 0)
+					feedWinner(losers[j - 1][2 * p + 1], n, 1)
+				}
+			}
+		}
+
+		final := add(grandFinal, 1)
+		// the reset is only played if the losers bracket champion wins
+		// the grand final, handing the other finalist its first loss
+		add(grandFinal, 2)
+		feedWinner(winners[rounds - 1][0], final, 0)
+		if len(losers) == 0 {
+			feedLoser(winners[rounds - 1][0], final, 1)
+		} else {
+			feedWinner(losers[len(losers) - 1][0], final, 1)
+		}
+	}
+
+	resolveByes(matches)
+	return matches
+}
+
+// decide records the winner of match n and moves both media on. A
+// winner of 0 means both slots were byes.
+func decide(matches []bracketMatch, n int, winner int64) {
+	match := &matches[n]
+	match.Decided = true
+	match.Winner = winner
+	loser := match.Media[0]
+	if winner == match.Media[0] {
+		loser = match.Media[1]
+	}
+
+	place := func(to, slot int, media int64) {
+		if to < 0 {
+			return
+		}
+		if media == 0 {
+			matches[to].Bye[slot] = true
+		} else {
+			matches[to].Media[slot] = media
+		}
+	}
+	place(match.WinnerTo, match.WinnerSlot, winner)
+	place(match.LoserTo, match.LoserSlot, loser)
+
+	if match.Section == grandFinal && match.Round == 1 {
+		reset := &matches[n + 1]
+		if winner != 0 && winner == match.Media[1] && match.Media[0] != 0 {
+			reset.Media = match.Media
+		} else {
+			reset.Bye = [2]bool{ true, true }
+		}
+	}
+
+	resolveByes(matches)
+}
+
+// resolveByes decides every match with a bye in favour of its entrant
+func resolveByes(matches []bracketMatch) {
+	for i := range(matches) {
+		match := &matches[i]
+		if match.Decided || !match.ready() || !(match.Bye[0] || match.Bye[1]) {
+			continue
+		}
+		winner := match.Media[0]
+		if match.Bye[0] {
+			winner = match.Media[1]
+		}
+		// deciding may fill earlier matches, so start over
+		decide(matches, i, winner)
+		return
+	}
+}
+
+// bracketChampion returns the winner of a bracket, or 0 while matches
+// are left.
+func bracketChampion(matches []bracketMatch) int64 {
+	var champion int64
+	for _, match := range(matches) {
+		if !match.Decided {
+			return 0
+		}
+		if match.Winner != 0 {
+			champion = match.Winner
+		}
+	}
+	return champion
+}
+
+// nextBracketMatch returns the number of the earliest playable match
+func nextBracketMatch(matches []bracketMatch) (int, bool) {
+	next := -1
+	for i, match := range(matches) {
+		if !match.playable() {
+			continue
+		}
+		if next < 0 || match.Section < matches[next].Section ||
+			match.Section == matches[next].Section && match.Round < matches[next].Round {
+			next = i
+		}
+	}
+	return next, next >= 0
+}
+
+type Bracket struct {
+	Id int64
+	Name string
+	Double bool
+	Entrants int
+	Champion MediaInfo
+	Sections []BracketSection
+}
+
+func (b Bracket) Finished() bool {
+	return b.Champion.Id != 0
+}
+
+type BracketSection struct {
+	Name string
+	Rounds [][]BracketMatch
+}
+
+type BracketMatch struct {
+	Number int
+	Media1 MediaInfo
+	Media2 MediaInfo
+	Bye1 bool
+	Bye2 bool
+	Decided bool
+	WinnerId int64
+}
+
+var BracketFinishedError = errors.New("bracket is finished")
+
+// BracketEntrants picks size media for a bracket, the highest ranked
+// ones or a random sample.
+func (s *Server) BracketEntrants(size int, top bool) ([]int64, error) {
+	if !top {
+		return s.index.Sample(size), nil
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT id FROM media ORDER BY %s DESC LIMIT ?", s.rankBy()), size)
+	if err != nil {
+		return nil, fmt.Errorf("BracketEntrants query failed: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, size)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("BracketEntrants scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("BracketEntrants rows: %w", rows.Err())
+	}
+	return ids, nil
+}
+
+// CreateBracket seeds ids into a new bracket, by rank or at random
+func (s *Server) CreateBracket(name string, double bool, ids []int64, seeding Seeding) (int64, error) {
+	if len(ids) < 2 {
+		return 0, NotEnoughMediaError
+	}
+
+	// the query drops duplicate and unknown ids along with ranking them
+	placeholders := strings.Repeat(", ?", len(ids))[2:]
+	args := make([]any, len(ids))
+	for i, id := range(ids) {
+		args[i] = id
+	}
+	query := fmt.Sprintf("SELECT id FROM media WHERE id IN (%s) ORDER BY %s DESC", placeholders, s.rankBy())
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("CreateBracket query seeds: %w", err)
+	}
+	defer rows.Close()
+	var seeds []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, fmt.Errorf("CreateBracket scan seed: %w", err)
+		}
+		seeds = append(seeds, id)
+	}
+	if rows.Err() != nil {
+		return 0, fmt.Errorf("CreateBracket seed rows: %w", rows.Err())
+	}
+	rows.Close()
+	if len(seeds) < 2 {
+		return 0, NotEnoughMediaError
+	}
+	if seeding == SeedRandom {
+		rand.Shuffle(len(seeds), func(i, j int) { seeds[i], seeds[j] = seeds[j], seeds[i] })
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("CreateBracket create new transaction: %w", err)
+	}
+
+	result, err := tx.Exec("INSERT INTO brackets(name, double_elimination, entrants) VALUES (?, ?, ?)", name, double, len(seeds))
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("CreateBracket insert bracket: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("CreateBracket get bracket id: %w", err)
+	}
+
+	for _, match := range(buildBracket(seeds, double)) {
+		_, err := tx.Exec(`
+INSERT INTO bracket_matches(bracket_id, number, section, round, winner_to, winner_slot, loser_to, loser_slot)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, match.Number, match.Section, match.Round, match.WinnerTo, match.WinnerSlot, match.LoserTo, match.LoserSlot,
+		)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("CreateBracket insert match: %w", err)
+		}
+		if err := saveBracketMatch(tx, id, match); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("CreateBracket: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("CreateBracket commit transaction: %w", err)
+	}
+
+	return id, nil
+}
+
+func loadBracketMatches(db querier, bracketId int64) ([]bracketMatch, error) {
+	rows, err := db.Query(`
+SELECT number, section, round, media1_id, media2_id, bye1, bye2, decided, winner_id, winner_to, winner_slot, loser_to, loser_slot
+FROM bracket_matches WHERE bracket_id = ? ORDER BY number`, bracketId)
+	if err != nil {
+		return nil, fmt.Errorf("load bracket matches query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []bracketMatch
+	for rows.Next() {
+		var match bracketMatch
+		var media1, media2, winner sql.NullInt64
+		err := rows.Scan(
+			&match.Number, &match.Section, &match.Round, &media1, &media2, &match.Bye[0], &match.Bye[1],
+			&match.Decided, &winner, &match.WinnerTo, &match.WinnerSlot, &match.LoserTo, &match.LoserSlot,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("load bracket matches scan row: %w", err)
+		}
+		match.Media = [2]int64{ media1.Int64, media2.Int64 }
+		match.Winner = winner.Int64
+		matches = append(matches, match)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("load bracket matches rows: %w", rows.Err())
+	}
+
+	return matches, nil
+}
+
+func saveBracketMatch(tx *sql.Tx, bracketId int64, match bracketMatch) error {
+	nullId := func(id int64) sql.NullInt64 {
+		return sql.NullInt64{ Int64: id, Valid: id != 0 }
+	}
+	_, err := tx.Exec(
+		"UPDATE bracket_matches SET media1_id = ?, media2_id = ?, bye1 = ?, bye2 = ?, decided = ?, winner_id = ? WHERE bracket_id = ? AND number = ?",
+		nullId(match.Media[0]), nullId(match.Media[1]), match.Bye[0], match.Bye[1], match.Decided, nullId(match.Winner), bracketId, match.Number,
+	)
+	if err != nil {
+		return fmt.Errorf("save bracket match %d: %w", match.Number, err)
+	}
+	return nil
+}
+
+const bracketQuery = "SELECT id, name, double_elimination, entrants, champion_id FROM brackets "
+
+func (s *Server) scanBracket(row rowScanner) (Bracket, error) {
+	var bracket Bracket
+	var champion sql.NullInt64
+	if err := row.Scan(&bracket.Id, &bracket.Name, &bracket.Double, &bracket.Entrants, &champion); err != nil {
+		return Bracket{}, err
+	}
+	if champion.Valid {
+		info, err := s.GetMediaInfo(champion.Int64)
+		if err != nil {
+			return Bracket{}, err
+		}
+		bracket.Champion = info
+	}
+	return bracket, nil
+}
+
+func (s *Server) Brackets() ([]Bracket, error) {
+	rows, err := s.db.Query(bracketQuery + "ORDER BY id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("Brackets query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var brackets []Bracket
+	for rows.Next() {
+		bracket, err := s.scanBracket(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Brackets scan row: %w", err)
+		}
+		brackets = append(brackets, bracket)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("Brackets rows: %w", rows.Err())
+	}
+
+	return brackets, nil
+}
+
+// GetBracket returns a bracket with every match laid out by section
+// and round.
+func (s *Server) GetBracket(id int64) (Bracket, error) {
+	bracket, err := s.scanBracket(s.db.QueryRow(bracketQuery + "WHERE id = ?", id))
+	if err != nil {
+		return Bracket{}, fmt.Errorf("GetBracket scan row: %w", err)
+	}
+
+	matches, err := loadBracketMatches(s.db, id)
+	if err != nil {
+		return Bracket{}, fmt.Errorf("GetBracket: %w", err)
+	}
+	var ids []int64
+	for _, match := range(matches) {
+		for _, media := range(match.Media) {
+			if media != 0 {
+				ids = append(ids, media)
+			}
+		}
+	}
+	list, err := s.mediaByIds(ids)
+	if err != nil {
+		return Bracket{}, fmt.Errorf("GetBracket: %w", err)
+	}
+	media := make(map[int64]MediaInfo, len(list))
+	for _, info := range(list) {
+		media[info.Id] = info
+	}
+
+	for _, match := range(matches) {
+		// the reset is left out unless it was played
+		if match.Section == grandFinal && match.Round == 2 && match.Media[0] == 0 {
+			continue
+		}
+		if len(bracket.Sections) == 0 || bracket.Sections[len(bracket.Sections) - 1].Name != match.Section.String() {
+			bracket.Sections = append(bracket.Sections, BracketSection{ Name: match.Section.String() })
+		}
+		section := &bracket.Sections[len(bracket.Sections) - 1]
+		if len(section.Rounds) < match.Round {
+			section.Rounds = append(section.Rounds, nil)
+		}
+		section.Rounds[match.Round - 1] = append(section.Rounds[match.Round - 1], BracketMatch{
+			Number: match.Number,
+			Media1: media[match.Media[0]],
+			Media2: media[match.Media[1]],
+			Bye1: match.Bye[0],
+			Bye2: match.Bye[1],
+			Decided: match.Decided,
+			WinnerId: match.Winner,
+		})
+	}
+
+	return bracket, nil
+}
+
+// NextBracketMatch returns the next match waiting on a vote, or
+// BracketFinishedError.
+func (s *Server) NextBracketMatch(bracketId int64) (BracketMatch, error) {
+	matches, err := loadBracketMatches(s.db, bracketId)
+	if err != nil {
+		return BracketMatch{}, fmt.Errorf("NextBracketMatch: %w", err)
+	}
+	n, ok := nextBracketMatch(matches)
+	if !ok {
+		return BracketMatch{}, BracketFinishedError
+	}
+
+	next := BracketMatch{ Number: n }
+	if next.Media1, err = s.GetMediaInfo(matches[n].Media[0]); err != nil {
+		return BracketMatch{}, fmt.Errorf("NextBracketMatch: %w", err)
+	}
+	if next.Media2, err = s.GetMediaInfo(matches[n].Media[1]); err != nil {
+		return BracketMatch{}, fmt.Errorf("NextBracketMatch: %w", err)
+	}
+	return next, nil
+}
+
+// RecordBracketResult records the winner of a bracket match as a
+// comparison and moves both media on through the bracket.
+func (s *Server) RecordBracketResult(bracketId int64, number int, winnerId int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("RecordBracketResult create new transaction: %w", err)
+	}
+
+	matches, err := loadBracketMatches(tx, bracketId)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordBracketResult: %w", err)
+	}
+	if number < 0 || number >= len(matches) || !matches[number].playable() {
+		tx.Rollback()
+		return fmt.Errorf("RecordBracketResult match %d of bracket %d isn't waiting on a vote", number, bracketId)
+	}
+	match := matches[number]
+	var loserId int64
+	switch winnerId {
+	case match.Media[0]:
+		loserId = match.Media[1]
+	case match.Media[1]:
+		loserId = match.Media[0]
+	default:
+		tx.Rollback()
+		return fmt.Errorf("RecordBracketResult media %d isn't in match %d", winnerId, number)
+	}
+
+	comparisonId, err := s.updateScoresTx(tx, globalLeaderboard, winnerId, loserId, OutcomeWin)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordBracketResult: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE bracket_matches SET comparison_id = ? WHERE bracket_id = ? AND number = ?", comparisonId, bracketId, number); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordBracketResult update comparison: %w", err)
+	}
+
+	decide(matches, number, winnerId)
+	for _, match := range(matches) {
+		if err := saveBracketMatch(tx, bracketId, match); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("RecordBracketResult: %w", err)
+		}
+	}
+	if champion := bracketChampion(matches); champion != 0 {
+		if _, err := tx.Exec("UPDATE brackets SET champion_id = ? WHERE id = ?", champion, bracketId); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("RecordBracketResult set champion: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("RecordBracketResult commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSeedOrder(t *testing.T) {
+	expected := []int{ 0, 7, 3, 4, 1, 6, 2, 5 }
+	if order := seedOrder(8); fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Errorf("expected seed order %v, found %v", expected, order)
+	}
+}
+
+// playBracket plays every match, picking winners with winner, and returns how
+// many matches were played
+func playBracket(matches []bracketMatch, winner func(int64, int64) int64) int {
+	played := 0
+	for {
+		n, ok := nextBracketMatch(matches)
+		if !ok {
+			return played
+		}
+		decide(matches, n, winner(matches[n].Media[0], matches[n].Media[1]))
+		played++
+	}
+}
+
+func lowestId(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestBuildBracket(t *testing.T) {
+	seeds := []int64{ 1, 2, 3, 4, 5, 6 }
+
+	t.Run("single elimination plays one match per eliminated media", func(t *testing.T) {
+		matches := buildBracket(seeds, false)
+		if played := playBracket(matches, lowestId); played != 5 {
+			t.Errorf("expected 5 matches, found %d", played)
+		}
+		if champion := bracketChampion(matches); champion != 1 {
+			t.Errorf("expected 1 to be champion, found %d", champion)
+		}
+	})
+
+	t.Run("byes go to the top seeds", func(t *testing.T) {
+		matches := buildBracket(seeds, false)
+		for _, match := range(matches) {
+			if match.Round == 1 && (match.Bye[0] || match.Bye[1]) {
+				if match.Winner != 1 && match.Winner != 2 {
+					t.Errorf("expected byes for seeds 1 and 2, found %d", match.Winner)
+				}
+			}
+		}
+	})
+
+	t.Run("double elimination without a reset", func(t *testing.T) {
+		matches := buildBracket(seeds, true)
+		if played := playBracket(matches, lowestId); played != 10 {
+			t.Errorf("expected 10 matches, found %d", played)
+		}
+		if champion := bracketChampion(matches); champion != 1 {
+			t.Errorf("expected 1 to be champion, found %d", champion)
+		}
+	})
+
+	t.Run("double elimination eliminates after two losses", func(t *testing.T) {
+		matches := buildBracket(seeds, true)
+		playBracket(matches, func(a, b int64) int64 {
+			if a > b {
+				return a
+			}
+			return b
+		})
+		losses := make(map[int64]int)
+		for _, match := range(matches) {
+			if match.Decided && match.Media[0] != 0 && match.Media[1] != 0 {
+				if match.Winner == match.Media[0] {
+					losses[match.Media[1]]++
+				} else {
+					losses[match.Media[0]]++
+				}
+			}
+		}
+		for _, id := range(seeds) {
+			if id == 6 && losses[id] != 0 {
+				t.Errorf("expected champion 6 to be undefeated, found %d losses", losses[id])
+			} else if id != 6 && losses[id] != 2 {
+				t.Errorf("expected %d to be eliminated after 2 losses, found %d", id, losses[id])
+			}
+		}
+	})
+
+	t.Run("grand final reset", func(t *testing.T) {
+		matches := buildBracket([]int64{ 1, 2 }, true)
+		// 2 beats 1 in the winners bracket, then loses the grand final
+		first := true
+		played := playBracket(matches, func(a, b int64) int64 {
+			if first {
+				first = false
+				return 2
+			}
+			return 1
+		})
+		if played != 3 {
+			t.Errorf("expected 3 matches, found %d", played)
+		}
+		if champion := bracketChampion(matches); champion != 1 {
+			t.Errorf("expected 1 to be champion, found %d", champion)
+		}
+	})
+}
+
+func TestBracket(t *testing.T) {
+	s := newServer(":memory:", t)
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		ids = append(ids, insertMedia(s, fmt.Sprintf("%d", i), fmt.Sprintf("%d", i), t))
+	}
+
+	if _, err := s.CreateBracket("too small", false, ids[:1], SeedByScore); !errors.Is(err, NotEnoughMediaError) {
+		t.Errorf("expected NotEnoughMediaError, found %v", err)
+	}
+	if _, err := s.CreateBracket("duplicates", false, []int64{ ids[0], ids[0], 9999 }, SeedRandom); !errors.Is(err, NotEnoughMediaError) {
+		t.Errorf("expected duplicate and unknown ids to be dropped, found %v", err)
+	}
+
+	id, err := s.CreateBracket("test", false, ids, SeedRandom)
+	if err != nil {
+		t.Fatalf("failed to create bracket: %s", err)
+	}
+	for {
+		match, err := s.NextBracketMatch(id)
+		if errors.Is(err, BracketFinishedError) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to get next match: %s", err)
+		}
+		winner := lowestId(match.Media1.Id, match.Media2.Id)
+		if err := s.RecordBracketResult(id, match.Number, winner); err != nil {
+			t.Fatalf("failed to record result: %s", err)
+		}
+		if err := s.RecordBracketResult(id, match.Number, winner); err == nil {
+			t.Fatalf("expected recording a decided match to fail")
+		}
+	}
+
+	count, err := s.ComparisonCount()
+	if err != nil {
+		t.Fatalf("failed to count comparisons: %s", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 comparisons, found %d", count)
+	}
+	comparisons, err := s.Comparisons(Scope{})
+	if err != nil {
+		t.Fatalf("failed to get comparisons: %s", err)
+	}
+	if err := s.DeleteComparison(comparisons[0].Id); !errors.Is(err, MatchComparisonError) {
+		t.Errorf("expected deleting a match to fail with MatchComparisonError, found %v", err)
+	}
+	bracket, err := s.GetBracket(id)
+	if err != nil {
+		t.Fatalf("failed to get bracket: %s", err)
+	}
+	if bracket.Champion.Id != ids[0] {
+		t.Errorf("expected %d to be champion, found %d", ids[0], bracket.Champion.Id)
+	}
+	if len(bracket.Sections) != 1 || len(bracket.Sections[0].Rounds) != 3 {
+		t.Errorf("expected 1 section of 3 rounds, found %+v", bracket.Sections)
+	}
+}
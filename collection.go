@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Collections are independent leaderboards over part of the library.
+// Media in a collection has a rating there separate from its global
+// one in the media table, and votes cast in a collection only move
+// ratings in that collection.
+
+// leaderboard identifies where ratings are kept, 0 is the global
+// leaderboard in the media table and anything else a collection id
+type leaderboard int64
+
+const globalLeaderboard leaderboard = 0
+
+// ratingsQuery selects every media on the leaderboard with its rating
+// there, in the order of mediaColumns. Conditions on the m alias can
+// be added with AND.
+func (l leaderboard) ratingsQuery() string {
+	if l == globalLeaderboard {
+		return `
+SELECT m.id id, m.path path, m.sha1sum sha1sum, m.score score, m.matches matches,
+  m.deviation deviation, m.volatility volatility, m.mu mu, m.sigma sigma
+FROM media m WHERE true`
+	}
+	return fmt.Sprintf(`
+SELECT m.id id, m.path path, m.sha1sum sha1sum, r.score score, r.matches matches,
+  r.deviation deviation, r.volatility volatility, r.mu mu, r.sigma sigma
+FROM collection_ratings r JOIN media m ON m.id = r.media_id WHERE r.collection_id = %d`, l)
+}
+
+// updateRating returns a statement setting set on the rating of the
+// media whose id is the last argument
+func (l leaderboard) updateRating(set string) string {
+	if l == globalLeaderboard {
+		return "UPDATE media SET " + set + " WHERE id = ?"
+	}
+	return fmt.Sprintf("UPDATE collection_ratings SET %s WHERE collection_id = %d AND media_id = ?", set, l)
+}
+
+// comparisons is the condition on the comparisons table that holds for
+// comparisons on the leaderboard
+func (l leaderboard) comparisons() string {
+	if l == globalLeaderboard {
+		return "collection_id IS NULL"
+	}
+	return fmt.Sprintf("collection_id = %d", l)
+}
+
+func (l leaderboard) collectionId() sql.NullInt64 {
+	return sql.NullInt64{ Int64: int64(l), Valid: l != globalLeaderboard }
+}
+
+func queryRating(db querier, board leaderboard, id int64) (MediaInfo, error) {
+	info, err := scanMediaInfo(db.QueryRow(board.ratingsQuery() + " AND m.id = ?", id))
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("query rating of %d: %w", id, err)
+	}
+	return info, nil
+}
+
+type Collection struct {
+	Id int64
+	Name string
+	// Scope decides which media belong to the collection
+	Scope Scope
+	Size int
+}
+
+// CreateCollection creates a collection of the media in scope
+func (s *Server) CreateCollection(name string, scope Scope) (int64, error) {
+	if scope.IsZero() {
+		return 0, fmt.Errorf("CreateCollection: a collection needs a folder, glob or tag")
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO collections(name, prefix, glob, tag) VALUES (?, ?, ?, ?)",
+		name, scope.Prefix, scope.Glob, scope.Tag,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("CreateCollection insert collection: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateCollection get collection id: %w", err)
+	}
+	if err := s.SyncCollections(); err != nil {
+		return 0, fmt.Errorf("CreateCollection: %w", err)
+	}
+	return id, nil
+}
+
+// SyncCollections adds media that came into the scope of a collection
+// since it was last synced, at the starting rating. Media stays in a
+// collection once added so its comparisons can always be replayed.
+func (s *Server) SyncCollections() error {
+	collections, err := s.Collections()
+	if err != nil {
+		return fmt.Errorf("SyncCollections: %w", err)
+	}
+	for _, collection := range(collections) {
+		where, args := collection.Scope.where("media")
+		args = append([]any{ collection.Id, initialScore, initialScore }, args...)
+		_, err := s.db.Exec(
+			"INSERT OR IGNORE INTO collection_ratings(collection_id, media_id, score, mu) SELECT ?, id, ?, ? FROM media WHERE " + where,
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("SyncCollections insert ratings of collection %d: %w", collection.Id, err)
+		}
+	}
+	return nil
+}
+
+const collectionQuery = `
+SELECT c.id, c.name, c.prefix, c.glob, c.tag,
+  (SELECT COUNT(*) FROM collection_ratings WHERE collection_id = c.id)
+FROM collections c
+`
+
+func scanCollection(row rowScanner) (Collection, error) {
+	var c Collection
+	err := row.Scan(&c.Id, &c.Name, &c.Scope.Prefix, &c.Scope.Glob, &c.Scope.Tag, &c.Size)
+	return c, err
+}
+
+func (s *Server) GetCollection(id int64) (Collection, error) {
+	collection, err := scanCollection(s.db.QueryRow(collectionQuery + "WHERE c.id = ?", id))
+	if err != nil {
+		return Collection{}, fmt.Errorf("GetCollection scan row: %w", err)
+	}
+	return collection, nil
+}
+
+func (s *Server) Collections() ([]Collection, error) {
+	rows, err := s.db.Query(collectionQuery + "ORDER BY c.name")
+	if err != nil {
+		return nil, fmt.Errorf("Collections query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		collection, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Collections scan row: %w", err)
+		}
+		collections = append(collections, collection)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("Collections rows: %w", rows.Err())
+	}
+
+	return collections, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCollections(t *testing.T) {
+	s := newServer(":memory:", t)
+	cat1 := insertMedia(s, "cats/a.jpg", "a", t)
+	cat2 := insertMedia(s, "cats/b.jpg", "b", t)
+	dog := insertMedia(s, "dogs/c.jpg", "c", t)
+
+	id, err := s.CreateCollection("Cats", Scope{ Prefix: "cats/" })
+	if err != nil {
+		t.Fatalf("failed to create collection: %s", err)
+	}
+	collection, err := s.GetCollection(id)
+	if err != nil {
+		t.Fatalf("failed to get collection: %s", err)
+	}
+	if collection.Name != "Cats" || collection.Size != 2 || collection.Scope.Prefix != "cats/" {
+		t.Errorf("unexpected collection %+v", collection)
+	}
+	if _, err := s.CreateCollection("Everything", Scope{}); err == nil {
+		t.Errorf("expected a collection without a scope to be refused")
+	}
+
+	if err := s.UpdateCollectionScores(id, cat1, cat2, OutcomeWin); err != nil {
+		t.Fatalf("failed to update collection scores: %s", err)
+	}
+	updateScores(s, cat2, cat1, t)
+
+	t.Run("collection ratings are independent", func(t *testing.T) {
+		list, err := s.SortedList(true, Scope{ Collection: id })
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		if len(list) != 2 || list[0].Id != cat1 || list[1].Id != cat2 {
+			t.Fatalf("expected %d ahead of %d in the collection, found %+v", cat1, cat2, list)
+		}
+		if list[0].Matches != 1 || list[0].Score <= initialScore {
+			t.Errorf("expected %d to have won its only collection match, found %+v", cat1, list[0])
+		}
+
+		global := getMediaInfo(s, cat1, t)
+		if global.Matches != 1 || global.Score >= initialScore {
+			t.Errorf("expected the collection vote to leave the global rating alone, found %+v", global)
+		}
+	})
+
+	t.Run("face-off weighs collection ratings", func(t *testing.T) {
+		s.selector = NewCoverageSelector()
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{ Scope: Scope{ Collection: id } })
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		if media1.Id == dog || media2.Id == dog || media1.Matches != 1 || media2.Matches != 1 {
+			t.Errorf("expected the cats with their collection ratings, found %+v and %+v", media1, media2)
+		}
+	})
+
+	t.Run("history is split by leaderboard", func(t *testing.T) {
+		comparisons, err := s.Comparisons(Scope{ Collection: id })
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if len(comparisons) != 1 || comparisons[0].Winner.Id != cat1 {
+			t.Errorf("expected only the collection comparison, found %+v", comparisons)
+		}
+		comparisons, err = s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if len(comparisons) != 1 || comparisons[0].Winner.Id != cat2 {
+			t.Errorf("expected only the global comparison, found %+v", comparisons)
+		}
+	})
+
+	t.Run("new media in scope joins on sync", func(t *testing.T) {
+		cat3 := insertMedia(s, "cats/d.jpg", "d", t)
+		if err := s.SyncCollections(); err != nil {
+			t.Fatalf("failed to sync collections: %s", err)
+		}
+		list, err := s.SortedList(true, Scope{ Collection: id })
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		for _, media := range(list) {
+			if media.Id == dog {
+				t.Errorf("expected %d to stay out of the collection", dog)
+			}
+		}
+		if len(list) != 3 || list[1].Id != cat3 || list[1].Score != initialScore {
+			t.Errorf("expected %d in the middle at the starting score, found %+v", cat3, list)
+		}
+	})
+
+	t.Run("undo only touches the collection", func(t *testing.T) {
+		if err := s.UndoLastCollectionComparison(id); err != nil {
+			t.Fatalf("failed to undo collection comparison: %s", err)
+		}
+		rating, err := queryRating(s.db, leaderboard(id), cat1)
+		if err != nil {
+			t.Fatalf("failed to get collection rating: %s", err)
+		}
+		if rating.Matches != 0 || rating.Score != initialScore {
+			t.Errorf("expected the collection rating to be restored, found %+v", rating)
+		}
+		if global := getMediaInfo(s, cat2, t); global.Matches != 1 {
+			t.Errorf("expected the global match to remain, found %+v", global)
+		}
+	})
+}
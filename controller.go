@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path"
@@ -19,23 +23,123 @@ type Controller struct {
 type IndexArgs struct {
 	Media1 MediaInfo
 	Media2 MediaInfo
+	// MinMatches is set when the coverage selector is in use, Covered
+	// of Total media have reached it.
+	MinMatches int
+	Covered int64
+	Total int64
+	// Options narrow the face-off to the top K or a score band, Query
+	// carries them over to the next face-off
+	Options SelectOptions
+	Query string
+	// ScopeQuery carries the scope over to the other pages
+	ScopeQuery string
+	// Placement is set when Media1 is being placed against the anchor
+	// Media2, Queued media are waiting for placement
+	Placement *Placement
+	Queued int
+}
+
+// MediaView is what the "media" template renders: media shown full size
+// on the face-off pages, or as one of many tiles on a page showing its
+// thumbnail
+type MediaView struct {
+	MediaInfo
+	// Tiled videos aren't fetched until played, so a page of tiles
+	// doesn't load every video on it
+	Tiled bool
+	// Unlinked leaves images without a link to the full media, for
+	// pages where clicking them does something else
+	Unlinked bool
+	Loser bool
+	Title string
+}
+
+// Full shows media full size, with its id and score on hover
+func (m MediaInfo) Full() MediaView {
+	return MediaView{ MediaInfo: m, Title: fmt.Sprintf("Id: %d, Score: %d, Path: %s", m.Id, m.Score, m.Path) }
+}
+
+// Tile shows media as a tile, images by their thumbnail
+func (m MediaInfo) Tile() MediaView {
+	return MediaView{ MediaInfo: m, Tiled: true, Title: m.Path }
+}
+
+// HasPoster reports whether a poster frame can be made for a video
+func (v MediaView) HasPoster() bool {
+	_, ok := thumbnailFile(v.Path, v.Sha1)
+	return v.IsVideo() && ok
+}
+
+func (v MediaView) Unlink() MediaView {
+	v.Unlinked = true
+	return v
+}
+
+// Lost greys out media that lost if lost is set
+func (v MediaView) Lost(lost bool) MediaView {
+	v.Loser = lost
+	return v
+}
+
+func (v MediaView) Titled(title string) MediaView {
+	v.Title = title
+	return v
 }
 
 func (c *Controller) Index(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.New("index").Parse(indexView)
+	tmpl, err := template.New("index").Parse(indexView + scopeView + mediaView)
 	if err != nil {
 		http.Error(w, "failed to parse template", 500)
 		log.Printf("Controller.Index failed to parse index template: %s", err)
 		return
 	}
-	media1, media2, err := c.s.SelectMediaForComparison()
+	opts, err := faceOffOptions(r)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), 400)
 		return
 	}
-	tmplArgs := IndexArgs{
-		Media1: media1,
-		Media2: media2,
+	opts.Session = session(w, r)
+	queued, err := c.s.PlacementCount()
+	if err != nil {
+		log.Printf("Controller.Index failed to count placements: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	tmplArgs := IndexArgs{ Options: opts, Queued: queued, ScopeQuery: scopeQuery(opts.Scope) }
+	if queued > 0 && opts.Top == 0 && opts.Band == nil && opts.Scope.IsZero() {
+		placement, anchor, ok, err := c.s.NextPlacement()
+		if err != nil {
+			log.Printf("Controller.Index failed to get next placement: %s", err)
+			http.Error(w, "DB failure", 500)
+			return
+		}
+		if ok {
+			tmplArgs.Media1 = placement.Media
+			tmplArgs.Media2 = anchor
+			tmplArgs.Placement = &placement
+		}
+	}
+	if tmplArgs.Placement == nil {
+		tmplArgs.Media1, tmplArgs.Media2, err = c.s.SelectMediaForComparison(opts)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if r.URL.RawQuery != "" {
+		tmplArgs.Query = "?" + r.URL.RawQuery
+	}
+	if coverage, ok := c.s.selector.(*CoverageSelector); ok {
+		covered, total, err := c.s.CoverageProgress(coverage.MinMatches)
+		if err != nil {
+			log.Printf("Controller.Index failed to get coverage progress: %s", err)
+			http.Error(w, "DB failure", 500)
+			return
+		}
+		tmplArgs.MinMatches = coverage.MinMatches
+		tmplArgs.Covered = covered
+		tmplArgs.Total = total
 	}
 	if err := tmpl.Execute(w, tmplArgs); err != nil {
 		http.Error(w, "failed to execute template", 500)
@@ -44,6 +148,67 @@ func (c *Controller) Index(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// faceOffOptions reads the top K or score band and the scope the
+// face-off is narrowed to from the query string
+func faceOffOptions(r *http.Request) (SelectOptions, error) {
+	query := r.URL.Query()
+	opts := SelectOptions{ Scope: ParseScope(query) }
+	if query.Get("top") != "" {
+		top, err := strconv.Atoi(query.Get("top"))
+		if err != nil || top < 2 {
+			return SelectOptions{}, fmt.Errorf("top must be a number of at least 2")
+		}
+		opts.Top = top
+	}
+	if query.Get("min") != "" || query.Get("max") != "" {
+		min, err := strconv.Atoi(query.Get("min"))
+		if err != nil {
+			return SelectOptions{}, fmt.Errorf("invalid minimum score")
+		}
+		max, err := strconv.Atoi(query.Get("max"))
+		if err != nil || max < min {
+			return SelectOptions{}, fmt.Errorf("invalid maximum score")
+		}
+		opts.Band = &ScoreBand{ Min: min, Max: max }
+	}
+	return opts, nil
+}
+
+// scopeQuery is the query string that keeps scope on another page
+func scopeQuery(scope Scope) string {
+	if scope.IsZero() {
+		return ""
+	}
+	return "?" + scope.Query().Encode()
+}
+
+// faceOffURL is the face-off page narrowed down the same way as the
+// request
+func faceOffURL(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return "/"
+	}
+	return "/?" + r.URL.RawQuery
+}
+
+const sessionCookie = "media-rank-session"
+
+// session returns the id of the browser making the request, handing it
+// a new one if it doesn't have one yet.
+func session(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("session failed to generate id: %s", err)
+		return ""
+	}
+	value := hex.EncodeToString(id)
+	http.SetCookie(w, &http.Cookie{ Name: sessionCookie, Value: value, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode })
+	return value
+}
+
 func (c *Controller) Media(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/media/"))
 	if err != nil {
@@ -71,16 +236,54 @@ func (c *Controller) Media(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to stat file", 500)
 		return
 	}
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", path.Base(mediaInfo.Path)))
+	if contentType := mediaContentType(mediaInfo.Path); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if mediaExt(mediaInfo.Path) == "svg" {
+		// keep scripts in svg files from running when opened directly
+		w.Header().Set("Content-Security-Policy", "script-src 'none'")
+	}
+
+	// ServeContent answers Range requests so videos can be seeked, and
+	// detects the type of everything else
+	http.ServeContent(w, r, path.Base(mediaInfo.Path), stat.ModTime(), f)
+}
 
-	// default response writer automatically detects mime types
-	_, err = io.Copy(w, f)
+// Thumb serves a scaled down copy of an image or a video's poster, or
+// an image itself if it can't be thumbnailed
+func (c *Controller) Thumb(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/thumb/"))
+	if err != nil {
+		http.Error(w, "invalid media id", 400)
+		return
+	}
+	mediaInfo, err := c.s.GetMediaInfo(int64(id))
+	if err != nil {
+		log.Printf("Controller.Thumb failed to retrieve media (%d) from db: %s", id, err)
+		http.Error(w, "invalid media id", 400)
+		return
+	}
+	// a video is only ever a poster, never served in its place
+	if _, ok := thumbnailFile(mediaInfo.Path, mediaInfo.Sha1); !ok {
+		if mediaInfo.IsVideo() {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/media/%d", id), 302)
+		return
+	}
+	thumbPath, err := c.s.Thumbnail(mediaInfo)
 	if err != nil {
-		log.Printf("Controller.Media failed to write media file: %s", err)
-		http.Error(w, "failed to write media file", 500)
+		log.Printf("Controller.Thumb failed to make thumbnail of \"%s\": %s", mediaInfo.Path, err)
+		if mediaInfo.IsVideo() {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/media/%d", id), 302)
 		return
 	}
+	http.ServeFile(w, r, thumbPath)
 }
 
 func (c *Controller) Vote(w http.ResponseWriter, r *http.Request) {
@@ -96,29 +299,296 @@ func (c *Controller) Vote(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request", 400)
 		return
 	}
-	log.Printf("winner: %s, loser: %s", winner, loser)
-	if err := c.s.UpdateScores(int64(winnerId), int64(loserId)); err != nil {
+	outcome, err := ParseOutcome(r.FormValue("outcome"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	log.Printf("winner: %s, loser: %s, outcome: %s", winner, loser, outcome)
+	if r.FormValue("placement") != "" {
+		if err := c.s.RecordPlacement(int64(winnerId), int64(loserId), outcome); err != nil {
+			log.Printf("Controller.Vote failed to record placement. winner: %d, loser: %d: %s", winnerId, loserId, err)
+			http.Error(w, "error updating database", 500)
+			return
+		}
+		http.Redirect(w, r, faceOffURL(r), 302)
+		return
+	}
+	if collection := ParseScope(r.URL.Query()).Collection; collection != 0 {
+		err = c.s.UpdateCollectionScores(collection, int64(winnerId), int64(loserId), outcome)
+	} else {
+		err = c.s.UpdateScores(int64(winnerId), int64(loserId), outcome)
+	}
+	if err != nil {
 		log.Printf("Controller.Vote failed to update scores. winner: %d, loser: %d", winnerId, loserId)
 		http.Error(w, "error updating database", 500)
 		return
 	}
-	http.Redirect(w, r, "/", 302)
+	http.Redirect(w, r, faceOffURL(r), 302)
+}
+
+const (
+	defaultGroupSize = 4
+	maxGroupSize = 9
+)
+
+// Candidate is media in a best of n face-off along with the key that
+// picks it
+type Candidate struct {
+	MediaInfo
+	Key int
+}
+
+// Multi is a best of n face-off, n is taken from the query string
+func (c *Controller) Multi(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.New("multi").Parse(multiView + mediaView)
+	if err != nil {
+		log.Printf("Controller.Multi failed to parse template: %s", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	n := defaultGroupSize
+	if r.FormValue("n") != "" {
+		n, err = strconv.Atoi(r.FormValue("n"))
+		if err != nil || n < 3 || n > maxGroupSize {
+			http.Error(w, fmt.Sprintf("n must be between 3 and %d", maxGroupSize), 400)
+			return
+		}
+	}
+	group, err := c.s.SelectMediaGroup(n)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	ids := make([]string, len(group))
+	candidates := make([]Candidate, len(group))
+	for i, media := range(group) {
+		ids[i] = strconv.FormatInt(media.Id, 10)
+		candidates[i] = Candidate{ MediaInfo: media, Key: i + 1 }
+	}
+	columns := 2
+	if len(group) > 4 {
+		columns = 3
+	}
+	args := struct {
+		Group []Candidate
+		Ids string
+		N int
+		Columns int
+	}{ Group: candidates, Ids: strings.Join(ids, ","), N: n, Columns: columns }
+	if err := tmpl.Execute(w, args); err != nil {
+		log.Printf("Controller.Multi failed to execute template: %s", err)
+		http.Error(w, "failed to execute template", 500)
+		return
+	}
+}
+
+// parseIds parses a comma separated list of ids
+func parseIds(list string) ([]int64, error) {
+	var ids []int64
+	for _, field := range(strings.Split(list, ",")) {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int64(id))
+	}
+	return ids, nil
+}
+
+// MultiVote records a best of n face-off. Either a winner is picked out
+// of ids, or order ranks every one of them.
+func (c *Controller) MultiVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	ids, err := parseIds(r.FormValue("ids"))
+	if err != nil || len(ids) < 2 {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	shown := make(map[int64]bool, len(ids))
+	for _, id := range(ids) {
+		shown[id] = true
+	}
+
+	var ranking []int64
+	complete := r.FormValue("order") != ""
+	if complete {
+		ranking, err = parseIds(r.FormValue("order"))
+		if err != nil || len(ranking) != len(ids) {
+			http.Error(w, "invalid order", 400)
+			return
+		}
+	} else {
+		winnerId, err := strconv.Atoi(r.FormValue("winner"))
+		if err != nil || !shown[int64(winnerId)] {
+			http.Error(w, "invalid request", 400)
+			return
+		}
+		ranking = append(ranking, int64(winnerId))
+		for _, id := range(ids) {
+			if id != int64(winnerId) {
+				ranking = append(ranking, id)
+			}
+		}
+	}
+	for _, id := range(ranking) {
+		if !shown[id] {
+			http.Error(w, "invalid order", 400)
+			return
+		}
+	}
+
+	log.Printf("ranking: %v, complete: %t", ranking, complete)
+	if err := c.s.RecordRanking(ranking, complete); err != nil {
+		log.Printf("Controller.MultiVote failed to record ranking %v: %s", ranking, err)
+		http.Error(w, "error updating database", 500)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/multi?n=%d", len(ids)), 302)
+}
+
+func (c *Controller) Placements(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.New("placements").Parse(placementView + mediaView)
+	if err != nil {
+		log.Printf("Controller.Placements failed to parse template: %s", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	queue, err := c.s.PlacementQueue()
+	if err != nil {
+		log.Printf("Controller.Placements failed to get placement queue: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	args := struct { Queue []Placement }{ Queue: queue }
+	if err := tmpl.Execute(w, args); err != nil {
+		log.Printf("Controller.Placements failed to execute template: %s", err)
+		http.Error(w, "failed to execute template", 500)
+		return
+	}
+}
+
+// ListEntry is a media item on the ranked list along with how
+// confident the rater is in its score and how often it was skipped
+type ListEntry struct {
+	MediaInfo
+	Confidence int
+	Skips int
+}
+
+// hardToJudgeSkips is how many skips it takes before media that is
+// skipped at least as often as it is voted on gets flagged.
+const hardToJudgeSkips = 3
+
+// HardToJudge reports whether the media is skipped so often that users
+// can't seem to rank it.
+func (e ListEntry) HardToJudge() bool {
+	return e.Skips >= hardToJudgeSkips && e.Skips >= e.Matches
+}
+
+func (c *Controller) Skip(w http.ResponseWriter, r *http.Request) {
+	media1Id, err := strconv.Atoi(r.FormValue("media1"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	media2Id, err := strconv.Atoi(r.FormValue("media2"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	if placement := r.FormValue("placement"); placement != "" {
+		mediaId, err := strconv.Atoi(placement)
+		if err != nil {
+			http.Error(w, "invalid request", 400)
+			return
+		}
+		if err := c.s.DeferPlacement(int64(mediaId)); err != nil {
+			log.Printf("Controller.Skip failed to defer placement of %d: %s", mediaId, err)
+			http.Error(w, "error updating database", 500)
+			return
+		}
+		http.Redirect(w, r, faceOffURL(r), 302)
+		return
+	}
+	if err := c.s.RecordSkip(int64(media1Id), int64(media2Id)); err != nil {
+		log.Printf("Controller.Skip failed to record skip. media1: %d, media2: %d: %s", media1Id, media2Id, err)
+		http.Error(w, "error updating database", 500)
+		return
+	}
+	http.Redirect(w, r, faceOffURL(r), 302)
+}
+
+func (c *Controller) Undo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	var err error
+	if collection := ParseScope(r.URL.Query()).Collection; collection != 0 {
+		err = c.s.UndoLastCollectionComparison(collection)
+	} else {
+		err = c.s.UndoLastComparison()
+	}
+	if errors.Is(err, MatchComparisonError) {
+		http.Error(w, "the last vote decided a tournament or bracket match and can't be undone", 409)
+		return
+	}
+	if err != nil && !errors.Is(err, NoComparisonsError) {
+		log.Printf("Controller.Undo failed to undo last comparison: %s", err)
+		http.Error(w, "error updating database", 500)
+		return
+	}
+	http.Redirect(w, r, faceOffURL(r), 302)
 }
 
 func (c *Controller) List(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.New("list").Parse(listView)
+	tmpl, err := template.New("list").Parse(listView + scopeView + mediaView)
 	if err != nil {
 		log.Printf("Controller.List failed to parse template: %s", err)
 		http.Error(w, "internal error", 500)
 		return
 	}
-	list, err := c.s.SortedList(true)
+	scope := ParseScope(r.URL.Query())
+	list, err := c.s.SortedList(true, scope)
 	if err != nil {
 		log.Printf("Controller.List failed to get sorted list: %s", err)
 		http.Error(w, "DB failure", 500)
 		return
 	}
-	args := struct { List []MediaInfo }{ List: list }
+	skips, err := c.s.SkipCounts()
+	if err != nil {
+		log.Printf("Controller.List failed to get skip counts: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	entries := make([]ListEntry, 0, len(list))
+	uncertainty, tracksUncertainty := c.s.rater.(uncertaintyRater)
+	for _, media := range(list) {
+		entry := ListEntry{ MediaInfo: media, Skips: skips[media.Id] }
+		if tracksUncertainty {
+			entry.Confidence = int(math.Round(uncertainty.Confidence(media)))
+		}
+		entries = append(entries, entry)
+	}
+	var k float64
+	if elo, ok := c.s.rater.(*EloRater); ok {
+		k = elo.K
+	}
+	args := struct {
+		List []ListEntry
+		ShowConfidence bool
+		K float64
+		Scope Scope
+		ScopeQuery string
+	}{ List: entries, ShowConfidence: tracksUncertainty, K: k, Scope: scope, ScopeQuery: scopeQuery(scope) }
 	if err := tmpl.Execute(w, args); err != nil {
 		http.Error(w, "failed to execute template", 500)
 		log.Printf("Controller.List failed to execute template: %s", err)
@@ -127,22 +597,408 @@ func (c *Controller) List(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Controller) History(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.New("History").Parse(historyTmpl)
+	tmpl, err := template.New("History").Parse(historyTmpl + scopeView + mediaView)
 	if err != nil {
 		log.Printf("Controller.History failed to parse template: %s", err)
 		http.Error(w, "internal error", 500)
 		return
 	}
-	comparisons, err := c.s.Comparisons()
+	scope := ParseScope(r.URL.Query())
+	comparisons, err := c.s.Comparisons(scope)
 	if err != nil {
 		log.Printf("Controller.Historyfailed to get comparisons: %s", err)
 		http.Error(w, "DB failure", 500)
 		return
 	}
-	args := struct { Comparisons []Comparison }{ Comparisons: comparisons }
+	args := struct {
+		Comparisons []Comparison
+		Scope Scope
+		ScopeQuery string
+	}{ Comparisons: comparisons, Scope: scope, ScopeQuery: scopeQuery(scope) }
 	if err := tmpl.Execute(w, args); err != nil {
 		log.Printf("Controller.History failed to execute template: %s", err)
 		http.Error(w, "failed to execute template", 500)
 		return
 	}
 }
+
+func (c *Controller) Recompute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	if err := c.s.RecomputeScores(); err != nil {
+		log.Printf("Controller.Recompute failed to recompute scores: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	http.Redirect(w, r, "/list", 302)
+}
+
+func (c *Controller) Replay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	var k float64
+	if r.FormValue("k") != "" {
+		var err error
+		k, err = strconv.ParseFloat(r.FormValue("k"), 64)
+		if err != nil || k <= 0 {
+			http.Error(w, "invalid k", 400)
+			return
+		}
+	}
+	start, err := strconv.Atoi(r.FormValue("start"))
+	if err != nil {
+		http.Error(w, "invalid starting rating", 400)
+		return
+	}
+	log.Printf("replaying comparisons, k: %f, start: %d", k, start)
+	if err := c.s.Replay(withK(c.s.rater, k), start); err != nil {
+		log.Printf("Controller.Replay failed to replay comparisons: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	http.Redirect(w, r, "/list", 302)
+}
+
+func (c *Controller) DeleteComparison(w http.ResponseWriter, r *http.Request) {
+	c.editComparison(w, r, c.s.DeleteComparison)
+}
+
+func (c *Controller) FlipComparison(w http.ResponseWriter, r *http.Request) {
+	c.editComparison(w, r, c.s.FlipComparison)
+}
+
+func (c *Controller) editComparison(w http.ResponseWriter, r *http.Request, edit func(int64) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "invalid comparison id", 400)
+		return
+	}
+	if err := edit(int64(id)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "invalid comparison id", 400)
+			return
+		}
+		if errors.Is(err, MatchComparisonError) {
+			http.Error(w, "this comparison decided a tournament or bracket match and can't be changed", 409)
+			return
+		}
+		log.Printf("Controller.editComparison failed to edit comparison %d: %s", id, err)
+		http.Error(w, "error updating database", 500)
+		return
+	}
+	http.Redirect(w, r, "/history" + scopeQuery(ParseScope(r.URL.Query())), 302)
+}
+
+// Tournaments lists the tournaments and, on POST, creates a new one
+func (c *Controller) Tournaments(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		rounds, err := strconv.Atoi(r.FormValue("rounds"))
+		if err != nil {
+			http.Error(w, "invalid number of rounds", 400)
+			return
+		}
+		var size int
+		if r.FormValue("size") != "" {
+			if size, err = strconv.Atoi(r.FormValue("size")); err != nil || size < 0 {
+				http.Error(w, "invalid number of entrants", 400)
+				return
+			}
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			name = "Tournament"
+		}
+		id, err := c.s.CreateTournament(name, rounds, size)
+		if err != nil {
+			log.Printf("Controller.Tournaments failed to create tournament: %s", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/tournament/%d", id), 302)
+		return
+	}
+
+	tmpl, err := template.New("tournaments").Parse(tournamentListView)
+	if err != nil {
+		log.Printf("Controller.Tournaments failed to parse template: %s", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	tournaments, err := c.s.Tournaments()
+	if err != nil {
+		log.Printf("Controller.Tournaments failed to get tournaments: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	args := struct { Tournaments []Tournament }{ Tournaments: tournaments }
+	if err := tmpl.Execute(w, args); err != nil {
+		log.Printf("Controller.Tournaments failed to execute template: %s", err)
+		http.Error(w, "failed to execute template", 500)
+		return
+	}
+}
+
+// Collections lists the collections and, on POST, creates a new one
+// from the submitted scope
+func (c *Controller) Collections(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid request", 400)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			http.Error(w, "a collection needs a name", 400)
+			return
+		}
+		scope := ParseScope(r.PostForm)
+		scope.Collection = 0
+		id, err := c.s.CreateCollection(name, scope)
+		if err != nil {
+			log.Printf("Controller.Collections failed to create collection: %s", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/list?collection=%d", id), 302)
+		return
+	}
+
+	tmpl, err := template.New("collections").Parse(collectionListView + scopeView)
+	if err != nil {
+		log.Printf("Controller.Collections failed to parse template: %s", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	collections, err := c.s.Collections()
+	if err != nil {
+		log.Printf("Controller.Collections failed to get collections: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	args := struct {
+		Collections []Collection
+		Scope Scope
+	}{ Collections: collections }
+	if err := tmpl.Execute(w, args); err != nil {
+		log.Printf("Controller.Collections failed to execute template: %s", err)
+		http.Error(w, "failed to execute template", 500)
+		return
+	}
+}
+
+func (c *Controller) Tournament(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/tournament/"))
+	if err != nil {
+		http.Error(w, "invalid tournament id", 400)
+		return
+	}
+	tmpl, err := template.New("tournament").Parse(tournamentView + mediaView)
+	if err != nil {
+		log.Printf("Controller.Tournament failed to parse template: %s", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	tournament, err := c.s.GetTournament(int64(id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "invalid tournament id", 404)
+			return
+		}
+		log.Printf("Controller.Tournament failed to get tournament %d: %s", id, err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	standings, err := c.s.TournamentStandings(int64(id))
+	if err != nil {
+		log.Printf("Controller.Tournament failed to get standings of tournament %d: %s", id, err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	match, err := c.s.NextTournamentMatch(int64(id))
+	if err != nil && !errors.Is(err, TournamentFinishedError) {
+		log.Printf("Controller.Tournament failed to get next match of tournament %d: %s", id, err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	args := struct {
+		Tournament Tournament
+		Standings []Standing
+		Match TournamentMatch
+	}{ Tournament: tournament, Standings: standings, Match: match }
+	if err := tmpl.Execute(w, args); err != nil {
+		log.Printf("Controller.Tournament failed to execute template: %s", err)
+		http.Error(w, "failed to execute template", 500)
+		return
+	}
+}
+
+func (c *Controller) TournamentVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	tournamentId, err := strconv.Atoi(r.FormValue("tournament"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	matchId, err := strconv.Atoi(r.FormValue("match"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	winnerId, err := strconv.Atoi(r.FormValue("winner"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	outcome, err := ParseOutcome(r.FormValue("outcome"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	if err := c.s.RecordTournamentResult(int64(tournamentId), int64(matchId), int64(winnerId), outcome); err != nil {
+		log.Printf("Controller.TournamentVote failed to record match %d: %s", matchId, err)
+		http.Error(w, "error updating database", 500)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/tournament/%d", tournamentId), 302)
+}
+
+// Brackets lists the brackets and, on POST, creates a new one from the
+// given media ids or the top or random size media
+func (c *Controller) Brackets(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		seeding, err := ParseSeeding(r.FormValue("seeding"))
+		if err != nil {
+			http.Error(w, "invalid seeding", 400)
+			return
+		}
+		var ids []int64
+		if r.FormValue("ids") != "" {
+			if ids, err = parseIds(r.FormValue("ids")); err != nil {
+				http.Error(w, "invalid media id", 400)
+				return
+			}
+		} else {
+			size, err := strconv.Atoi(r.FormValue("size"))
+			if err != nil || size < 2 {
+				http.Error(w, "invalid number of entrants", 400)
+				return
+			}
+			ids, err = c.s.BracketEntrants(size, r.FormValue("pool") == "top")
+			if err != nil {
+				log.Printf("Controller.Brackets failed to pick entrants: %s", err)
+				http.Error(w, "DB failure", 500)
+				return
+			}
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			name = "Bracket"
+		}
+		id, err := c.s.CreateBracket(name, r.FormValue("elimination") == "double", ids, seeding)
+		if err != nil {
+			log.Printf("Controller.Brackets failed to create bracket: %s", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/bracket/%d", id), 302)
+		return
+	}
+
+	tmpl, err := template.New("brackets").Parse(bracketListView + mediaView)
+	if err != nil {
+		log.Printf("Controller.Brackets failed to parse template: %s", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	brackets, err := c.s.Brackets()
+	if err != nil {
+		log.Printf("Controller.Brackets failed to get brackets: %s", err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	args := struct { Brackets []Bracket }{ Brackets: brackets }
+	if err := tmpl.Execute(w, args); err != nil {
+		log.Printf("Controller.Brackets failed to execute template: %s", err)
+		http.Error(w, "failed to execute template", 500)
+		return
+	}
+}
+
+func (c *Controller) Bracket(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/bracket/"))
+	if err != nil {
+		http.Error(w, "invalid bracket id", 400)
+		return
+	}
+	tmpl, err := template.New("bracket").Parse(bracketView + mediaView)
+	if err != nil {
+		log.Printf("Controller.Bracket failed to parse template: %s", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	bracket, err := c.s.GetBracket(int64(id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "invalid bracket id", 404)
+			return
+		}
+		log.Printf("Controller.Bracket failed to get bracket %d: %s", id, err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	next, err := c.s.NextBracketMatch(int64(id))
+	if err != nil && !errors.Is(err, BracketFinishedError) {
+		log.Printf("Controller.Bracket failed to get next match of bracket %d: %s", id, err)
+		http.Error(w, "DB failure", 500)
+		return
+	}
+	args := struct {
+		Bracket Bracket
+		Next BracketMatch
+		Playing bool
+	}{ Bracket: bracket, Next: next, Playing: err == nil }
+	if err := tmpl.Execute(w, args); err != nil {
+		log.Printf("Controller.Bracket failed to execute template: %s", err)
+		http.Error(w, "failed to execute template", 500)
+		return
+	}
+}
+
+func (c *Controller) BracketVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	bracketId, err := strconv.Atoi(r.FormValue("bracket"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	number, err := strconv.Atoi(r.FormValue("match"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	winnerId, err := strconv.Atoi(r.FormValue("winner"))
+	if err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	if err := c.s.RecordBracketResult(int64(bracketId), number, int64(winnerId)); err != nil {
+		log.Printf("Controller.BracketVote failed to record match %d: %s", number, err)
+		http.Error(w, "error updating database", 500)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/bracket/%d", bracketId), 302)
+}
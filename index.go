@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// mediaIndex keeps the id of every media in memory so random media can
+// be picked without ORDER BY RANDOM() scanning the whole table. The
+// server keeps it in step with inserts and deletes.
+type mediaIndex struct {
+	mutex sync.RWMutex
+	ids []int64
+	positions map[int64]int
+}
+
+func newMediaIndex(ids []int64) *mediaIndex {
+	index := &mediaIndex{ positions: make(map[int64]int, len(ids)) }
+	for _, id := range(ids) {
+		index.add(id)
+	}
+	return index
+}
+
+func (i *mediaIndex) Add(id int64) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.add(id)
+}
+
+func (i *mediaIndex) add(id int64) {
+	if _, ok := i.positions[id]; ok {
+		return
+	}
+	i.positions[id] = len(i.ids)
+	i.ids = append(i.ids, id)
+}
+
+func (i *mediaIndex) Remove(id int64) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	position, ok := i.positions[id]
+	if !ok {
+		return
+	}
+	last := len(i.ids) - 1
+	i.ids[position] = i.ids[last]
+	i.positions[i.ids[position]] = position
+	i.ids = i.ids[:last]
+	delete(i.positions, id)
+}
+
+func (i *mediaIndex) Len() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return len(i.ids)
+}
+
+// Sample returns up to n different ids picked at random.
+func (i *mediaIndex) Sample(n int) []int64 {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if n >= len(i.ids) {
+		sample := make([]int64, len(i.ids))
+		copy(sample, i.ids)
+		rand.Shuffle(len(sample), func(a, b int) {
+			sample[a], sample[b] = sample[b], sample[a]
+		})
+		return sample
+	}
+
+	sample := make([]int64, 0, n)
+	picked := make(map[int]bool, n)
+	for len(sample) < n {
+		position := rand.Intn(len(i.ids))
+		if picked[position] {
+			continue
+		}
+		picked[position] = true
+		sample = append(sample, i.ids[position])
+	}
+	return sample
+}
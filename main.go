@@ -17,8 +17,45 @@ const (
 func main() {
 	userAddress := flag.String("addr", address, "address:port to start the server on")
 	mediaDirectory := flag.String("media", ".", "location of media directory")
+	rating := flag.String("rating", "elo", fmt.Sprintf("rating system to use (%s)", raterNames()))
+	k := flag.Float64("k", 30, "Elo development coefficient (K-factor)")
+	kSchedule := flag.String("k-schedule", "", "Elo K-factor schedule as matches:k steps, e.g. \"30:20,100:10\" lowers -k to 20 after 30 matches and 10 after 100")
+	selection := flag.String("selection", "random", fmt.Sprintf("how face-off pairs are picked (%s)", selectorNames()))
+	minMatches := flag.Int("min-matches", 5, "matches every media should reach before the coverage selection offers well compared media again")
+	top := flag.Int("top", 50, "number of highest ranked media the top selection compares")
+	placeNew := flag.Bool("place-new", false, "queue uncompared media found by the scan for placement by binary search against established media")
+	recentItems := flag.Int("recent-items", 2, "number of face-offs before the same media is offered again")
+	recentPairs := flag.Int("recent-pairs", 20, "number of face-offs before the same pair is offered again")
+	recentGlobal := flag.Bool("recent-global", false, "share the recently offered memory between all browsers instead of per session")
+	start := flag.Int("start", initialScore, "starting rating used by the replay command")
+	extraTypes := flag.String("extra-types", "", "extra file extensions to scan, comma separated as ext or ext:content/type, e.g. \"bmp,jxl:image/jxl\"")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  recompute\n        refit every score with Bradley-Terry from the comparison history")
+		fmt.Fprintln(flag.CommandLine.Output(), "  replay\n        reset every score to -start and re-apply every comparison in order")
+		fmt.Fprintln(flag.CommandLine.Output(), "  tag <tag> <glob>\n        tag every media whose path matches glob, to scope pages with ?tag=")
+		fmt.Fprintln(flag.CommandLine.Output(), "Flags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	rater, err := NewRater(*rating)
+	if err != nil {
+		log.Fatalf("invalid rating system: %s", err)
+	}
+	selector, err := NewSelector(*selection)
+	if err != nil {
+		log.Fatalf("invalid selection strategy: %s", err)
+	}
+	schedule, err := ParseKSchedule(*kSchedule)
+	if err != nil {
+		log.Fatalf("invalid K schedule: %s", err)
+	}
+	if err := AddMediaTypes(*extraTypes); err != nil {
+		log.Fatalf("invalid extra types: %s", err)
+	}
+
 	if err := os.Chdir(*mediaDirectory); err != nil {
 		log.Fatalf("failed to change to media directory: %s", err)
 	}
@@ -27,6 +64,23 @@ func main() {
 	if err != nil {
 		log.Fatalf("creating new server: %s", err)
 	}
+	server.rater = withSchedule(withK(rater, *k), schedule)
+	if coverage, ok := selector.(*CoverageSelector); ok {
+		coverage.MinMatches = *minMatches
+	}
+	if head, ok := selector.(*TopSelector); ok {
+		head.K = *top
+	}
+	server.selector = selector
+	server.placeNew = *placeNew
+	server.recent = newRecentMemory(*recentItems, *recentPairs, *recentGlobal)
+
+	if command := flag.Arg(0); command != "" {
+		if err := runCommand(server, command, flag.Args()[1:], *start); err != nil {
+			log.Fatalf("%s failed: %s", command, err)
+		}
+		return
+	}
 
 	ctx := context.Background()
 	log.Printf("beginning media scan of %s\n", *mediaDirectory)
@@ -52,23 +106,17 @@ func main() {
 		fmt.Println()
 		log.Printf("finished media scan, total: %d", finished)
 
-		row := server.db.QueryRow("SELECT COUNT(*) FROM media WHERE deleted = true")
-		if row.Err() != nil {
-			log.Fatalf("main failed to get number of deleted files: %s", err)
-			os.Exit(1)
+		// Delete media marked by scanMedia
+		removed, err := server.RemoveDeletedMedia()
+		if err != nil {
+			log.Fatalf("main failed to remove deleted media: %s", err)
 		}
-		var deletedFiles int
-		if err := row.Scan(&deletedFiles); err != nil {
-			log.Fatalf("main failed to scan deleted files: %s", err)
-			os.Exit(1)
+		if removed > 0 {
+			log.Printf("Removed %d deleted files from database", removed)
 		}
-		if deletedFiles > 0 {
-			log.Printf("Removing %d deleted files from database", deletedFiles)
-			// Delete media marked by scanMedia
-			if _, err := server.db.Exec("DELETE FROM media WHERE deleted = true"); err != nil {
-				log.Fatalf("main failed to remove deleted media: %s", err)
-				os.Exit(1)
-			}
+
+		if err := server.SyncCollections(); err != nil {
+			log.Printf("main failed to sync collections: %s", err)
 		}
 	}()
 
@@ -81,3 +129,34 @@ func main() {
 		log.Fatalf("HTTP server failed: %s\n", err)
 	}
 }
+
+// runCommand runs a one-off subcommand against the database instead
+// of starting the server.
+func runCommand(server *Server, command string, args []string, start int) error {
+	switch command {
+	case "recompute":
+		log.Println("recomputing Bradley-Terry scores from comparison history")
+		if err := server.RecomputeScores(); err != nil {
+			return err
+		}
+		log.Println("finished recomputing scores")
+	case "replay":
+		log.Printf("replaying comparison history from a starting rating of %d", start)
+		if err := server.Replay(server.rater, start); err != nil {
+			return err
+		}
+		log.Println("finished replaying comparisons")
+	case "tag":
+		if len(args) != 2 {
+			return fmt.Errorf("expected a tag and a glob, found %d arguments", len(args))
+		}
+		tagged, err := server.TagMedia(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		log.Printf("tagged %d media %s", tagged, args[0])
+	default:
+		return fmt.Errorf("unknown command \"%s\"", command)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// New media can be placed with a binary search instead of drifting up
+// from the starting score. Each placement vote pits it against the
+// anchor in the middle of the part of the ranking it could still
+// belong to, so it finds its spot in about log2(N) votes.
+
+// anchorMatches is how many matches media needs before its rank is
+// trusted enough to place new media against
+const anchorMatches = 5
+
+// placementMargin is how far past the best or worst anchor media
+// placed at either end of the ranking is scored
+const placementMargin = 25
+
+type Placement struct {
+	Media MediaInfo
+	// Low and High bound the anchor ranks the media can still be
+	// placed between
+	Low int
+	High int
+	Steps int
+}
+
+// Remaining estimates how many votes are left to place the media
+func (p Placement) Remaining() int {
+	steps := 0
+	for n := p.High - p.Low; n > 0; n /= 2 {
+		steps++
+	}
+	return steps
+}
+
+// anchorQuery ranks the media trusted to place new media against
+func (s *Server) anchorQuery(columns string) string {
+	return fmt.Sprintf(
+		"SELECT %s FROM media WHERE matches >= %d AND id NOT IN (SELECT media_id FROM placements) ORDER BY %s DESC",
+		columns, anchorMatches, s.rankBy(),
+	)
+}
+
+func (s *Server) anchorCount(db querier) (int, error) {
+	var count int
+	row := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (%s)", s.anchorQuery("id")))
+	if err := row.Scan(&count); err != nil {
+		return 0, fmt.Errorf("anchor count scan row: %w", err)
+	}
+	return count, nil
+}
+
+// anchorAt returns the anchor at rank, best first
+func (s *Server) anchorAt(db querier, rank int) (MediaInfo, error) {
+	media, err := scanMediaInfo(db.QueryRow(s.anchorQuery(mediaColumns) + " LIMIT 1 OFFSET ?", rank))
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("anchor at %d scan row: %w", rank, err)
+	}
+	return media, nil
+}
+
+// queuePlacement adds media to the end of the placement queue, as long
+// as there are anchors to place it against
+func (s *Server) queuePlacement(mediaId int64) error {
+	count, err := s.anchorCount(s.db)
+	if err != nil {
+		return fmt.Errorf("queue placement: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+	_, err = s.db.Exec(`
+INSERT OR IGNORE INTO placements(media_id, position, low, high)
+SELECT ?, COALESCE(MAX(position), 0) + 1, 0, ? FROM placements`, mediaId, count)
+	if err != nil {
+		return fmt.Errorf("queue placement insert: %w", err)
+	}
+	return nil
+}
+
+const placementQuery = "SELECT " + mediaColumns + ", p.low, p.high, p.steps FROM placements p JOIN media ON media.id = p.media_id "
+
+func scanPlacement(row rowScanner) (Placement, error) {
+	var p Placement
+	m := &p.Media
+	err := row.Scan(&m.Id, &m.Path, &m.Sha1, &m.Score, &m.Matches, &m.Deviation, &m.Volatility, &m.Mu, &m.Sigma, &p.Low, &p.High, &p.Steps)
+	return p, err
+}
+
+func (s *Server) PlacementCount() (int, error) {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM placements").Scan(&count); err != nil {
+		return 0, fmt.Errorf("PlacementCount scan row: %w", err)
+	}
+	return count, nil
+}
+
+// PlacementQueue returns the media waiting for placement, in order
+func (s *Server) PlacementQueue() ([]Placement, error) {
+	rows, err := s.db.Query(placementQuery + "ORDER BY p.position")
+	if err != nil {
+		return nil, fmt.Errorf("PlacementQueue query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var queue []Placement
+	for rows.Next() {
+		placement, err := scanPlacement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("PlacementQueue scan row: %w", err)
+		}
+		queue = append(queue, placement)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("PlacementQueue rows: %w", rows.Err())
+	}
+
+	return queue, nil
+}
+
+// NextPlacement returns the media at the front of the placement queue
+// and the anchor to compare it against. ok is false if the queue is
+// empty.
+func (s *Server) NextPlacement() (Placement, MediaInfo, bool, error) {
+	placement, err := scanPlacement(s.db.QueryRow(placementQuery + "ORDER BY p.position LIMIT 1"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Placement{}, MediaInfo{}, false, nil
+	}
+	if err != nil {
+		return Placement{}, MediaInfo{}, false, fmt.Errorf("NextPlacement scan row: %w", err)
+	}
+
+	count, err := s.anchorCount(s.db)
+	if err != nil {
+		return Placement{}, MediaInfo{}, false, fmt.Errorf("NextPlacement: %w", err)
+	}
+	if count == 0 {
+		return Placement{}, MediaInfo{}, false, nil
+	}
+	placement.Low, placement.High = searchBounds(placement.Low, placement.High, count)
+
+	anchor, err := s.anchorAt(s.db, (placement.Low + placement.High) / 2)
+	if err != nil {
+		return Placement{}, MediaInfo{}, false, fmt.Errorf("NextPlacement: %w", err)
+	}
+	return placement, anchor, true, nil
+}
+
+// searchBounds fits the stored search bounds to the current number of
+// anchors, which can shrink while media waits. If nothing is left
+// between them the search is reopened on the last anchor.
+func searchBounds(low, high, count int) (int, int) {
+	if high > count {
+		high = count
+	}
+	if low >= high {
+		low = high - 1
+	}
+	if low < 0 {
+		low = 0
+	}
+	return low, high
+}
+
+// RecordPlacement records a placement vote as a comparison and narrows
+// down where the queued media belongs. Once the search is over the
+// media is scored between its neighbouring anchors and leaves the
+// queue. A draw places it right at the anchor.
+func (s *Server) RecordPlacement(winnerId, loserId int64, outcome Outcome) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("RecordPlacement create new transaction: %w", err)
+	}
+
+	placement, err := scanPlacement(tx.QueryRow(placementQuery + "WHERE p.media_id IN (?, ?) ORDER BY p.position LIMIT 1", winnerId, loserId))
+	if errors.Is(err, sql.ErrNoRows) {
+		// the media was placed meanwhile, record a regular vote
+		if _, err := s.updateScoresTx(tx, globalLeaderboard, winnerId, loserId, outcome); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("RecordPlacement: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("RecordPlacement commit transaction: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordPlacement scan placement: %w", err)
+	}
+
+	count, err := s.anchorCount(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordPlacement: %w", err)
+	}
+	low, high := searchBounds(placement.Low, placement.High, count)
+	mid := (low + high) / 2
+	switch {
+	case outcome == OutcomeDraw:
+		low, high = mid, mid
+	case winnerId == placement.Media.Id:
+		high = mid
+	default:
+		low = mid + 1
+	}
+
+	comparisonId, err := s.updateScoresTx(tx, globalLeaderboard, winnerId, loserId, outcome)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordPlacement: %w", err)
+	}
+	_, err = tx.Exec(`
+INSERT INTO placement_steps(comparison_id, media_id, position, low, high, steps)
+SELECT ?, media_id, position, low, high, steps FROM placements WHERE media_id = ?`,
+		comparisonId, placement.Media.Id,
+	)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordPlacement insert step: %w", err)
+	}
+
+	if low < high {
+		_, err = tx.Exec("UPDATE placements SET low = ?, high = ?, steps = steps + 1 WHERE media_id = ?", low, high, placement.Media.Id)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("RecordPlacement update placement: %w", err)
+		}
+	} else if err := s.finishPlacement(tx, comparisonId, placement.Media.Id, low, count); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordPlacement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("RecordPlacement commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// finishPlacement scores media between the anchors ranked just above
+// and below rank and takes it off the queue. The jump is added to the
+// points of the comparison that finished the placement so undo takes
+// it back, and the score is kept for replays.
+func (s *Server) finishPlacement(tx *sql.Tx, comparisonId int64, mediaId int64, rank int, count int) error {
+	var score int
+	switch {
+	case count == 0:
+		score = initialScore
+	case rank == 0:
+		best, err := s.anchorAt(tx, 0)
+		if err != nil {
+			return fmt.Errorf("finish placement: %w", err)
+		}
+		score = best.Score + placementMargin
+	case rank >= count:
+		worst, err := s.anchorAt(tx, count - 1)
+		if err != nil {
+			return fmt.Errorf("finish placement: %w", err)
+		}
+		score = worst.Score - placementMargin
+	default:
+		above, err := s.anchorAt(tx, rank - 1)
+		if err != nil {
+			return fmt.Errorf("finish placement: %w", err)
+		}
+		below, err := s.anchorAt(tx, rank)
+		if err != nil {
+			return fmt.Errorf("finish placement: %w", err)
+		}
+		score = (above.Score + below.Score) / 2
+	}
+
+	var previous int
+	if err := tx.QueryRow("SELECT score FROM media WHERE id = ?", mediaId).Scan(&previous); err != nil {
+		return fmt.Errorf("finish placement scan score: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE media SET score = ?, mu = ? WHERE id = ?", score, score, mediaId); err != nil {
+		return fmt.Errorf("finish placement update score: %w", err)
+	}
+	jump := score - previous
+	_, err := tx.Exec(`
+UPDATE comparisons SET
+  points = points + CASE WHEN winner_id = ? THEN ? ELSE 0 END,
+  loser_points = loser_points + CASE WHEN loser_id = ? THEN ? ELSE 0 END
+WHERE id = ?`,
+		mediaId, jump, mediaId, jump, comparisonId,
+	)
+	if err != nil {
+		return fmt.Errorf("finish placement update comparison: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE placement_steps SET placed_score = ? WHERE comparison_id = ?", score, comparisonId); err != nil {
+		return fmt.Errorf("finish placement update step: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM placements WHERE media_id = ?", mediaId); err != nil {
+		return fmt.Errorf("finish placement delete placement: %w", err)
+	}
+	return nil
+}
+
+// restorePlacement puts media back in the placement queue as it was
+// before comparison id, if that was a placement vote
+func restorePlacement(tx *sql.Tx, id int64) error {
+	_, err := tx.Exec(`
+INSERT OR REPLACE INTO placements(media_id, position, low, high, steps)
+SELECT media_id, position, low, high, steps FROM placement_steps WHERE comparison_id = ?`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("restore placement: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM placement_steps WHERE comparison_id = ?", id); err != nil {
+		return fmt.Errorf("restore placement delete step: %w", err)
+	}
+	return nil
+}
+
+// DeferPlacement moves media to the back of the placement queue
+func (s *Server) DeferPlacement(mediaId int64) error {
+	_, err := s.db.Exec(
+		"UPDATE placements SET position = (SELECT MAX(position) + 1 FROM placements) WHERE media_id = ?",
+		mediaId,
+	)
+	if err != nil {
+		return fmt.Errorf("DeferPlacement update failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPlacement(t *testing.T) {
+	s := newServer(":memory:", t)
+	s.placeNew = true
+
+	// Without anchors there is nothing to place against
+	first := insertMedia(s, "first", "first", t)
+	if count, err := s.PlacementCount(); err != nil || count != 0 {
+		t.Fatalf("expected empty queue without anchors, found %d: %v", count, err)
+	}
+	if _, err := s.db.Exec("DELETE FROM media WHERE id = ?", first); err != nil {
+		t.Fatalf("failed to delete media: %s", err)
+	}
+
+	s.placeNew = false
+
+	for i := 0; i < 16; i++ {
+		id := insertMedia(s, fmt.Sprintf("anchor%d", i), fmt.Sprintf("anchor%d", i), t)
+		_, err := s.db.Exec("UPDATE media SET score = ?, matches = ? WHERE id = ?", 1000 + i * 100, anchorMatches, id)
+		if err != nil {
+			t.Fatalf("failed to set up anchor: %s", err)
+		}
+	}
+	s.placeNew = true
+
+	// Media that truly rates 1850 beats every anchor scored below it
+	id := insertMedia(s, "new", "new", t)
+	queue, err := s.PlacementQueue()
+	if err != nil {
+		t.Fatalf("failed to get placement queue: %s", err)
+	}
+	if len(queue) != 1 || queue[0].Media.Id != id || queue[0].Remaining() != 5 {
+		t.Fatalf("expected new media queued with 5 votes to go, found %+v", queue)
+	}
+
+	votes := 0
+	var beforeLast MediaInfo
+	for {
+		placement, anchor, ok, err := s.NextPlacement()
+		if err != nil {
+			t.Fatalf("failed to get next placement: %s", err)
+		}
+		if !ok {
+			break
+		}
+		if placement.Media.Id != id {
+			t.Fatalf("expected %d to be placed, found %d", id, placement.Media.Id)
+		}
+		winner, loser := anchor.Id, id
+		if anchor.Score < 1850 {
+			winner, loser = id, anchor.Id
+		}
+		beforeLast = getMediaInfo(s, id, t)
+		if err := s.RecordPlacement(winner, loser, OutcomeWin); err != nil {
+			t.Fatalf("failed to record placement: %s", err)
+		}
+		votes++
+		if votes > 5 {
+			t.Fatalf("expected placement to take at most 5 votes")
+		}
+	}
+
+	list, err := s.SortedList(true, Scope{})
+	if err != nil {
+		t.Fatalf("failed to get sorted list: %s", err)
+	}
+	// anchors 8 through 15 are scored 1800 and up
+	for rank, media := range(list) {
+		if media.Id == id && rank != 7 {
+			t.Errorf("expected new media placed at rank 7, found %d with score %d", rank, media.Score)
+		}
+	}
+	count, err := s.ComparisonCount()
+	if err != nil {
+		t.Fatalf("failed to count comparisons: %s", err)
+	}
+	if count != int64(votes) {
+		t.Errorf("expected %d comparisons, found %d", votes, count)
+	}
+
+	// undoing the last vote takes back the placement as well
+	placed := getMediaInfo(s, id, t)
+	if err := s.UndoLastComparison(); err != nil {
+		t.Fatalf("failed to undo: %s", err)
+	}
+	compareMediaInfo("undone", beforeLast, getMediaInfo(s, id, t), t)
+	queue, err = s.PlacementQueue()
+	if err != nil {
+		t.Fatalf("failed to get placement queue: %s", err)
+	}
+	if len(queue) != 1 || queue[0].Media.Id != id || queue[0].Steps != votes - 1 || queue[0].Remaining() != 1 {
+		t.Fatalf("expected %d back in the queue one vote from placed, found %+v", id, queue)
+	}
+
+	// voting again places it at the same score, which replays keep
+	_, anchor, ok, err := s.NextPlacement()
+	if err != nil || !ok {
+		t.Fatalf("expected a placement vote, found %v", err)
+	}
+	winner, loser := anchor.Id, id
+	if anchor.Score < 1850 {
+		winner, loser = id, anchor.Id
+	}
+	if err := s.RecordPlacement(winner, loser, OutcomeWin); err != nil {
+		t.Fatalf("failed to record placement: %s", err)
+	}
+	compareMediaInfo("placed again", placed, getMediaInfo(s, id, t), t)
+	if err := s.Replay(s.rater, initialScore); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+	if replayed := getMediaInfo(s, id, t); replayed.Score != placed.Score {
+		t.Errorf("expected replay to keep the placed score %d, found %d", placed.Score, replayed.Score)
+	}
+}
+
+func TestSearchBounds(t *testing.T) {
+	if low, high := searchBounds(2, 10, 20); low != 2 || high != 10 {
+		t.Errorf("expected bounds to stay 2, 10, found %d, %d", low, high)
+	}
+	if low, high := searchBounds(8, 10, 6); low != 5 || high != 6 {
+		t.Errorf("expected bounds to be reopened on the last anchor, found %d, %d", low, high)
+	}
+}
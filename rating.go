@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rater calculates the new ratings of two media after a comparison.
+// Server.UpdateScores delegates to a Rater, so the rating system can
+// be swapped without touching the comparisons table.
+type Rater interface {
+	// Rate returns copies of winner and loser with their rating
+	// fields updated to reflect the outcome of the comparison. On a
+	// draw winner and loser are just the left and right media.
+	Rate(winner, loser MediaInfo, outcome Outcome) (MediaInfo, MediaInfo)
+}
+
+// Outcome is the result of a comparison, stored in the comparisons
+// table's outcome column.
+type Outcome int
+
+const (
+	OutcomeWin Outcome = iota
+	OutcomeDraw
+)
+
+// ParseOutcome parses the outcome form value sent by a vote. An empty
+// value is a win.
+func ParseOutcome(value string) (Outcome, error) {
+	switch value {
+	case "", "win":
+		return OutcomeWin, nil
+	case "draw":
+		return OutcomeDraw, nil
+	default:
+		return 0, fmt.Errorf("unknown outcome \"%s\"", value)
+	}
+}
+
+func (o Outcome) String() string {
+	if o == OutcomeDraw {
+		return "draw"
+	}
+	return "win"
+}
+
+// Score returns the winner's share of the comparison, 1 for a win
+// and 0.5 for a draw. The loser gets the rest.
+func (o Outcome) Score() float64 {
+	if o == OutcomeDraw {
+		return 0.5
+	}
+	return 1.0
+}
+
+// Starting values of the rating fields for media that haven't been
+// compared yet, matching the media table's column defaults.
+const (
+	initialScore = 1500
+	initialDeviation = 350.0
+	initialVolatility = 0.06
+	initialSigma = 500.0
+)
+
+// resetRating returns media with every rating field back at its
+// starting value, with start as its score and mean.
+func resetRating(media MediaInfo, start int) MediaInfo {
+	media.Score = start
+	media.Matches = 0
+	media.Deviation = initialDeviation
+	media.Volatility = initialVolatility
+	media.Mu = float64(start)
+	media.Sigma = initialSigma
+	return media
+}
+
+// uncertaintyRater is implemented by raters that track how sure they
+// are of each rating.
+type uncertaintyRater interface {
+	// Confidence returns the half width of the confidence interval
+	// around media's score.
+	Confidence(media MediaInfo) float64
+}
+
+// kRater is implemented by raters with a K-factor, the K used for each
+// side is stored with every comparison.
+type kRater interface {
+	KFor(media MediaInfo) float64
+}
+
+// rankedRater is implemented by raters that rank media by something
+// other than the raw score.
+type rankedRater interface {
+	// RankExpression returns an SQL expression over the media
+	// table's columns to sort by.
+	RankExpression() string
+}
+
+var raters = map[string]func() Rater{
+	"elo": func() Rater { return NewEloRater() },
+	"glicko2": func() Rater { return NewGlicko2Rater() },
+	"trueskill": func() Rater { return NewTrueSkillRater() },
+}
+
+// NewRater returns the rating system registered under name.
+func NewRater(name string) (Rater, error) {
+	newRater, ok := raters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown rating system \"%s\", expected one of: %s", name, raterNames())
+	}
+	return newRater(), nil
+}
+
+func raterNames() string {
+	names := make([]string, 0, len(raters))
+	for name := range(raters) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// EloRater is the classic Elo rating system.
+type EloRater struct {
+	// K is the development coefficient, the maximum number of
+	// points a single comparison can move a score.
+	K float64
+	// Influence is the score difference at which the stronger
+	// media is expected to win ten times as often.
+	Influence float64
+	// Schedule lowers K for media as it gains matches, sorted by
+	// ascending Matches.
+	Schedule []KStep
+}
+
+// KStep sets the K-factor of media with at least Matches matches.
+type KStep struct {
+	Matches int
+	K float64
+}
+
+func NewEloRater() *EloRater {
+	return &EloRater{ K: 30.0, Influence: 400.0 }
+}
+
+func (e *EloRater) Rate(winner, loser MediaInfo, outcome Outcome) (MediaInfo, MediaInfo) {
+	winner.Score, loser.Score = e.newScores(winner.Score, loser.Score, outcome.Score(), e.KFor(winner), e.KFor(loser))
+	return winner, loser
+}
+
+// KFor returns the K-factor used for media given its match count.
+func (e *EloRater) KFor(media MediaInfo) float64 {
+	k := e.K
+	for _, step := range(e.Schedule) {
+		if media.Matches >= step.Matches {
+			k = step.K
+		}
+	}
+	return k
+}
+
+func (e *EloRater) newScores(winnerScore, loserScore int, actual, winnerK, loserK float64) (winnerNewScore, loserNewScore int) {
+	// Good reference https://www.omnicalculator.com/sports/elo
+	winnerExpectation := 1/(1 + math.Pow(10, float64(loserScore - winnerScore) / e.Influence))
+	loserExpectation := 1.0 - winnerExpectation
+	winnerNewScore = winnerScore + int(winnerK * (actual - winnerExpectation))
+	loserNewScore = loserScore + int(loserK * ((1.0 - actual) - loserExpectation))
+	return winnerNewScore, loserNewScore
+}
+
+// ParseKSchedule parses a K-factor schedule of comma separated
+// matches:k steps, such as "30:20,100:10" to use K 20 from 30 matches
+// and K 10 from 100 matches.
+func ParseKSchedule(value string) ([]KStep, error) {
+	var schedule []KStep
+	if strings.TrimSpace(value) == "" {
+		return schedule, nil
+	}
+	for _, field := range(strings.Split(value, ",")) {
+		matches, k, found := strings.Cut(strings.TrimSpace(field), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid K schedule step \"%s\", expected matches:k", field)
+		}
+		var step KStep
+		var err error
+		if step.Matches, err = strconv.Atoi(matches); err != nil || step.Matches < 0 {
+			return nil, fmt.Errorf("invalid K schedule matches \"%s\"", matches)
+		}
+		if step.K, err = strconv.ParseFloat(k, 64); err != nil || step.K <= 0 {
+			return nil, fmt.Errorf("invalid K schedule k \"%s\"", k)
+		}
+		schedule = append(schedule, step)
+	}
+	sort.Slice(schedule, func(i, j int) bool {
+		return schedule[i].Matches < schedule[j].Matches
+	})
+	return schedule, nil
+}
+
+// withSchedule returns a copy of rater using the K-factor schedule, if
+// it is an Elo rater.
+func withSchedule(rater Rater, schedule []KStep) Rater {
+	if elo, ok := rater.(*EloRater); ok {
+		tuned := *elo
+		tuned.Schedule = schedule
+		return &tuned
+	}
+	return rater
+}
+
+// withK returns a copy of rater using the development coefficient k,
+// if it is an Elo rater and k is set.
+func withK(rater Rater, k float64) Rater {
+	if elo, ok := rater.(*EloRater); ok && k > 0 {
+		tuned := *elo
+		tuned.K = k
+		return &tuned
+	}
+	return rater
+}
+
+func calculateNewEloScores(winnerScore, loserScore int) (winnerNewScore, loserNewScore int) {
+	elo := NewEloRater()
+	return elo.newScores(winnerScore, loserScore, OutcomeWin.Score(), elo.K, elo.K)
+}
+
+// glicko2Scale converts between the Glicko and Glicko-2 rating scales.
+const glicko2Scale = 173.7178
+
+// Glicko2Rater is Mark Glickman's Glicko-2 rating system. Each
+// comparison is treated as its own rating period, so a media item's
+// deviation shrinks as it is compared and its rating settles.
+// http://www.glicko.net/glicko/glicko2.pdf
+type Glicko2Rater struct {
+	// Tau constrains how much volatility can change per comparison.
+	Tau float64
+}
+
+func NewGlicko2Rater() *Glicko2Rater {
+	return &Glicko2Rater{ Tau: 0.5 }
+}
+
+func (g *Glicko2Rater) Rate(winner, loser MediaInfo, outcome Outcome) (MediaInfo, MediaInfo) {
+	winnerNew := g.update(winner, []glicko2Result{{ opponent: loser, score: outcome.Score() }})
+	loserNew := g.update(loser, []glicko2Result{{ opponent: winner, score: 1.0 - outcome.Score() }})
+	return winnerNew, loserNew
+}
+
+// Confidence returns the half width of the ~95% confidence interval
+// around media's rating.
+func (g *Glicko2Rater) Confidence(media MediaInfo) float64 {
+	return 2 * media.Deviation
+}
+
+type glicko2Result struct {
+	opponent MediaInfo
+	score float64
+}
+
+func (g *Glicko2Rater) update(media MediaInfo, results []glicko2Result) MediaInfo {
+	mu := (float64(media.Score) - 1500) / glicko2Scale
+	phi := media.Deviation / glicko2Scale
+	sigma := media.Volatility
+
+	var vInverse, deltaSum float64
+	for _, result := range(results) {
+		opponentMu := (float64(result.opponent.Score) - 1500) / glicko2Scale
+		opponentG := glicko2G(result.opponent.Deviation / glicko2Scale)
+		expected := 1 / (1 + math.Exp(-opponentG * (mu - opponentMu)))
+		vInverse += opponentG * opponentG * expected * (1 - expected)
+		deltaSum += opponentG * (result.score - expected)
+	}
+	v := 1 / vInverse
+	delta := v * deltaSum
+
+	sigmaNew := g.volatility(phi, sigma, v, delta)
+	phiStar := math.Sqrt(phi * phi + sigmaNew * sigmaNew)
+	phiNew := 1 / math.Sqrt(1 / (phiStar * phiStar) + 1 / v)
+	muNew := mu + phiNew * phiNew * deltaSum
+
+	media.Score = int(math.Round(muNew * glicko2Scale + 1500))
+	media.Deviation = phiNew * glicko2Scale
+	media.Volatility = sigmaNew
+	return media
+}
+
+// volatility finds the new volatility using the Illinois algorithm
+// from step 5 of the Glicko-2 paper.
+func (g *Glicko2Rater) volatility(phi, sigma, v, delta float64) float64 {
+	const epsilon = 0.000001
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		denominator := phi * phi + v + ex
+		return ex * (delta * delta - phi * phi - v - ex) / (2 * denominator * denominator) - (x - a) / (g.Tau * g.Tau)
+	}
+
+	A := a
+	var B float64
+	if delta * delta > phi * phi + v {
+		B = math.Log(delta * delta - phi * phi - v)
+	} else {
+		k := 1.0
+		for f(a - k * g.Tau) < 0 {
+			k++
+		}
+		B = a - k * g.Tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B - A) > epsilon {
+		C := A + (A - B) * fA / (fB - fA)
+		fC := f(C)
+		if fC * fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA = fA / 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
+
+func glicko2G(phi float64) float64 {
+	return 1 / math.Sqrt(1 + 3 * phi * phi / (math.Pi * math.Pi))
+}
+
+// TrueSkillRater is a two player version of Microsoft's TrueSkill.
+// Every media item has a mean skill (Mu) and uncertainty (Sigma) and
+// is ranked by the conservative estimate Mu - 3*Sigma, so a new item
+// has to prove itself before it climbs the list. It uses the same
+// scale as Elo, Score mirrors Mu.
+// https://www.moserware.com/assets/computing-your-skill/The%20Math%20Behind%20TrueSkill.pdf
+type TrueSkillRater struct {
+	// Beta is the skill difference that gives the stronger media
+	// roughly a 76% chance of winning.
+	Beta float64
+	// Tau is added to sigma before every comparison so ratings
+	// never stop moving entirely.
+	Tau float64
+	// DrawProbability is how often two media of equal skill are
+	// expected to draw.
+	DrawProbability float64
+}
+
+func NewTrueSkillRater() *TrueSkillRater {
+	return &TrueSkillRater{ Beta: 250, Tau: 5, DrawProbability: 0.1 }
+}
+
+func (ts *TrueSkillRater) Rate(winner, loser MediaInfo, outcome Outcome) (MediaInfo, MediaInfo) {
+	winnerVariance := winner.Sigma * winner.Sigma + ts.Tau * ts.Tau
+	loserVariance := loser.Sigma * loser.Sigma + ts.Tau * ts.Tau
+	c := math.Sqrt(2 * ts.Beta * ts.Beta + winnerVariance + loserVariance)
+
+	t := (winner.Mu - loser.Mu) / c
+	margin := ts.drawMargin() / c
+	var v, w float64
+	if outcome == OutcomeDraw {
+		denominator := normalCDF(margin - t) - normalCDF(-margin - t)
+		v = (normalPDF(-margin - t) - normalPDF(margin - t)) / denominator
+		w = v * v + ((margin - t) * normalPDF(margin - t) + (margin + t) * normalPDF(margin + t)) / denominator
+	} else {
+		v = normalPDF(t - margin) / normalCDF(t - margin)
+		w = v * (v + t - margin)
+	}
+
+	winner.Mu += winnerVariance / c * v
+	loser.Mu -= loserVariance / c * v
+	winner.Sigma = math.Sqrt(winnerVariance * (1 - winnerVariance / (c * c) * w))
+	loser.Sigma = math.Sqrt(loserVariance * (1 - loserVariance / (c * c) * w))
+	winner.Score = int(math.Round(winner.Mu))
+	loser.Score = int(math.Round(loser.Mu))
+
+	return winner, loser
+}
+
+// drawMargin is the performance difference under which a comparison
+// is considered a draw.
+func (ts *TrueSkillRater) drawMargin() float64 {
+	return normalQuantile((ts.DrawProbability + 1) / 2) * math.Sqrt2 * ts.Beta
+}
+
+func (ts *TrueSkillRater) Confidence(media MediaInfo) float64 {
+	return 3 * media.Sigma
+}
+
+func (ts *TrueSkillRater) RankExpression() string {
+	return "mu - 3 * sigma"
+}
+
+func normalPDF(x float64) float64 {
+	return math.Exp(-x * x / 2) / math.Sqrt(2 * math.Pi)
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x / math.Sqrt2)
+}
+
+func normalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2 * p - 1)
+}
+
+// pairResult is a single comparison between two media.
+type pairResult struct {
+	winnerId int64
+	loserId int64
+	outcome Outcome
+}
+
+// fitBradleyTerry finds the maximum likelihood Bradley-Terry strength
+// of every media in ids given every comparison made, using Hunter's
+// MM algorithm. Unlike Elo the result doesn't depend on the order of
+// the comparisons. A draw counts as half a win for each side. Each
+// media gets one virtual win and one virtual loss against an opponent
+// of strength 1 so media that never won or never lost still have a
+// finite strength, and that opponent anchors the returned scores at
+// 1500 on the Elo scale.
+// https://doi.org/10.1214/aos/1079120141
+func fitBradleyTerry(ids []int64, results []pairResult) map[int64]int {
+	const (
+		maxIterations = 1000
+		tolerance = 1e-9
+	)
+
+	index := make(map[int64]int, len(ids))
+	for i, id := range(ids) {
+		index[id] = i
+	}
+
+	wins := make([]float64, len(ids))
+	for i := range(wins) {
+		wins[i] = 1
+	}
+	pairs := make([][2]int, 0, len(results))
+	for _, result := range(results) {
+		winner, winnerOk := index[result.winnerId]
+		loser, loserOk := index[result.loserId]
+		if !winnerOk || !loserOk {
+			continue
+		}
+		wins[winner] += result.outcome.Score()
+		wins[loser] += 1 - result.outcome.Score()
+		pairs = append(pairs, [2]int{ winner, loser })
+	}
+
+	strengths := make([]float64, len(ids))
+	for i := range(strengths) {
+		strengths[i] = 1
+	}
+	denominators := make([]float64, len(ids))
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		for i, strength := range(strengths) {
+			denominators[i] = 2 / (strength + 1)
+		}
+		for _, pair := range(pairs) {
+			inverse := 1 / (strengths[pair[0]] + strengths[pair[1]])
+			denominators[pair[0]] += inverse
+			denominators[pair[1]] += inverse
+		}
+
+		var maxChange float64
+		for i := range(strengths) {
+			updated := wins[i] / denominators[i]
+			maxChange = math.Max(maxChange, math.Abs(math.Log(updated / strengths[i])))
+			strengths[i] = updated
+		}
+		if maxChange < tolerance {
+			break
+		}
+	}
+
+	scores := make(map[int64]int, len(ids))
+	for i, id := range(ids) {
+		scores[id] = 1500 + int(math.Round(400 * math.Log10(strengths[i])))
+	}
+	return scores
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculateNewEloScores(t *testing.T) {
+	tests := []struct{
+		winnerBefore int
+		loserBefore int
+		winnerAfter int
+		loserAfter int
+	}{
+		{ 1500, 1500, 1515, 1485 },
+		{ 1300, 1500, 1322, 1478 },
+		{ 1250, 1670, 1277, 1643 },
+		{ 1720, 1321, 1722, 1319 },
+	}
+	for _, test := range(tests) {
+		winnerNewScore, loserNewScore := calculateNewEloScores(test.winnerBefore, test.loserBefore)
+		if winnerNewScore != test.winnerAfter {
+			t.Errorf("winnerBefore: %d, loserBefore: %d, expected winnerNewScore to be %d, found %d",
+				test.winnerBefore, test.loserBefore, test.winnerAfter, winnerNewScore)
+		}
+		if loserNewScore != test.loserAfter {
+			t.Errorf("winnerBefore: %d, loserBefore: %d, expected loserNewScore to be %d, found %d",
+				test.winnerBefore, test.loserBefore, test.loserAfter, loserNewScore)
+		}
+	}
+}
+
+func TestNewRater(t *testing.T) {
+	rater, err := NewRater("elo")
+	if err != nil {
+		t.Fatalf("failed to create elo rater: %s", err)
+	}
+	if _, ok := rater.(*EloRater); !ok {
+		t.Errorf("expected elo to return *EloRater, found %T", rater)
+	}
+	if _, err := NewRater("nonexistent"); err == nil {
+		t.Error("expected unknown rating system to return an error")
+	}
+}
+
+func TestGlicko2Update(t *testing.T) {
+	// Worked example from the Glicko-2 paper
+	g := &Glicko2Rater{ Tau: 0.5 }
+	player := MediaInfo{ Score: 1500, Deviation: 200, Volatility: 0.06 }
+	results := []glicko2Result{
+		{ opponent: MediaInfo{ Score: 1400, Deviation: 30 }, score: 1 },
+		{ opponent: MediaInfo{ Score: 1550, Deviation: 100 }, score: 0 },
+		{ opponent: MediaInfo{ Score: 1700, Deviation: 300 }, score: 0 },
+	}
+	updated := g.update(player, results)
+	if updated.Score != 1464 {
+		t.Errorf("expected Score to be 1464, found %d", updated.Score)
+	}
+	if math.Abs(updated.Deviation - 151.52) > 0.01 {
+		t.Errorf("expected Deviation to be 151.52, found %f", updated.Deviation)
+	}
+	if math.Abs(updated.Volatility - 0.05999) > 0.00001 {
+		t.Errorf("expected Volatility to be 0.05999, found %f", updated.Volatility)
+	}
+}
+
+func TestGlicko2Rate(t *testing.T) {
+	g := NewGlicko2Rater()
+	fresh := MediaInfo{ Score: 1500, Deviation: 350, Volatility: 0.06 }
+	settled := MediaInfo{ Score: 1500, Deviation: 50, Volatility: 0.06 }
+	winner, loser := g.Rate(fresh, settled, OutcomeWin)
+	if winner.Score <= fresh.Score {
+		t.Errorf("expected winner score to increase from %d, found %d", fresh.Score, winner.Score)
+	}
+	if loser.Score >= settled.Score {
+		t.Errorf("expected loser score to decrease from %d, found %d", settled.Score, loser.Score)
+	}
+	if winner.Score - fresh.Score <= settled.Score - loser.Score {
+		t.Errorf("expected uncertain winner to move more than settled loser, moved %d and %d",
+			winner.Score - fresh.Score, settled.Score - loser.Score)
+	}
+	if winner.Deviation >= fresh.Deviation {
+		t.Errorf("expected winner deviation to shrink from %f, found %f", fresh.Deviation, winner.Deviation)
+	}
+}
+
+func TestTrueSkillRate(t *testing.T) {
+	ts := NewTrueSkillRater()
+	fresh := MediaInfo{ Score: 1500, Mu: 1500, Sigma: 500 }
+	winner, loser := ts.Rate(fresh, fresh, OutcomeWin)
+	if winner.Mu <= fresh.Mu || loser.Mu >= fresh.Mu {
+		t.Errorf("expected means to move apart, found %f and %f", winner.Mu, loser.Mu)
+	}
+	if math.Abs((winner.Mu - fresh.Mu) - (fresh.Mu - loser.Mu)) > 0.0001 {
+		t.Errorf("expected equal players to move the same amount, found %f and %f", winner.Mu - fresh.Mu, fresh.Mu - loser.Mu)
+	}
+	if winner.Sigma >= fresh.Sigma || loser.Sigma >= fresh.Sigma {
+		t.Errorf("expected uncertainty to shrink, found %f and %f", winner.Sigma, loser.Sigma)
+	}
+	if winner.Score != int(math.Round(winner.Mu)) {
+		t.Errorf("expected Score to mirror Mu %f, found %d", winner.Mu, winner.Score)
+	}
+	if winner.Mu - 3 * winner.Sigma >= fresh.Mu {
+		t.Errorf("expected one win to leave the conservative estimate below the starting mean, found %f", winner.Mu - 3 * winner.Sigma)
+	}
+}
+
+func TestFitBradleyTerry(t *testing.T) {
+	ids := []int64{ 1, 2, 3, 4 }
+	results := []pairResult{
+		{ 1, 2, OutcomeWin }, { 1, 2, OutcomeWin }, { 2, 1, OutcomeWin },
+		{ 2, 3, OutcomeWin }, { 2, 3, OutcomeWin },
+		{ 1, 3, OutcomeWin },
+	}
+	scores := fitBradleyTerry(ids, results)
+	if !(scores[1] > scores[2] && scores[2] > scores[3]) {
+		t.Errorf("expected scores to be ordered 1 > 2 > 3, found %v", scores)
+	}
+	if scores[4] != 1500 {
+		t.Errorf("expected uncompared media to stay at 1500, found %d", scores[4])
+	}
+
+	reversed := make([]pairResult, len(results))
+	for i, result := range(results) {
+		reversed[len(results) - 1 - i] = result
+	}
+	reversedScores := fitBradleyTerry(ids, reversed)
+	for _, id := range(ids) {
+		if scores[id] != reversedScores[id] {
+			t.Errorf("expected media %d score to be independent of order, found %d and %d", id, scores[id], reversedScores[id])
+		}
+	}
+}
+
+func TestRateDraw(t *testing.T) {
+	fresh := MediaInfo{ Score: 1500, Deviation: 350, Volatility: 0.06, Mu: 1500, Sigma: 500 }
+	strong := MediaInfo{ Score: 1700, Deviation: 350, Volatility: 0.06, Mu: 1700, Sigma: 500 }
+	for name, rater := range(map[string]Rater{
+		"elo": NewEloRater(),
+		"glicko2": NewGlicko2Rater(),
+		"trueskill": NewTrueSkillRater(),
+	}) {
+		left, right := rater.Rate(fresh, fresh, OutcomeDraw)
+		if left.Score != fresh.Score || right.Score != fresh.Score {
+			t.Errorf("%s: expected equal media drawing to keep their scores, found %d and %d", name, left.Score, right.Score)
+		}
+		weak, strongNew := rater.Rate(fresh, strong, OutcomeDraw)
+		if weak.Score <= fresh.Score {
+			t.Errorf("%s: expected weaker media to gain from a draw, found %d", name, weak.Score)
+		}
+		if strongNew.Score >= strong.Score {
+			t.Errorf("%s: expected stronger media to lose from a draw, found %d", name, strongNew.Score)
+		}
+	}
+}
+
+func TestParseOutcome(t *testing.T) {
+	tests := []struct{
+		value string
+		outcome Outcome
+		fails bool
+	}{
+		{ "", OutcomeWin, false },
+		{ "win", OutcomeWin, false },
+		{ "draw", OutcomeDraw, false },
+		{ "loss", 0, true },
+	}
+	for _, test := range(tests) {
+		outcome, err := ParseOutcome(test.value)
+		if (err != nil) != test.fails {
+			t.Errorf("ParseOutcome(\"%s\") unexpected error result: %v", test.value, err)
+		}
+		if outcome != test.outcome {
+			t.Errorf("ParseOutcome(\"%s\") expected %d, found %d", test.value, test.outcome, outcome)
+		}
+	}
+}
+
+func TestParseKSchedule(t *testing.T) {
+	schedule, err := ParseKSchedule("100:10, 30:20")
+	if err != nil {
+		t.Fatalf("failed to parse K schedule: %s", err)
+	}
+	if len(schedule) != 2 || schedule[0] != (KStep{ 30, 20 }) || schedule[1] != (KStep{ 100, 10 }) {
+		t.Errorf("expected schedule sorted by matches, found %v", schedule)
+	}
+	for _, invalid := range([]string{ "30", "a:20", "30:b", "30:-1", "-5:10" }) {
+		if _, err := ParseKSchedule(invalid); err == nil {
+			t.Errorf("expected \"%s\" to fail to parse", invalid)
+		}
+	}
+
+	elo := &EloRater{ K: 40, Influence: 400, Schedule: schedule }
+	tests := []struct{
+		matches int
+		k float64
+	}{
+		{ 0, 40 }, { 29, 40 }, { 30, 20 }, { 99, 20 }, { 100, 10 }, { 500, 10 },
+	}
+	for _, test := range(tests) {
+		if k := elo.KFor(MediaInfo{ Matches: test.matches }); k != test.k {
+			t.Errorf("expected K for %d matches to be %f, found %f", test.matches, test.k, k)
+		}
+	}
+}
@@ -6,7 +6,24 @@ func SetupRoutes(s *Server) {
 	controller := Controller{ s: s }
 	http.HandleFunc("/", controller.Index)
 	http.HandleFunc("/media/", controller.Media)
+	http.HandleFunc("/thumb/", controller.Thumb)
 	http.HandleFunc("/vote", controller.Vote)
+	http.HandleFunc("/vote/undo", controller.Undo)
+	http.HandleFunc("/skip", controller.Skip)
+	http.HandleFunc("/placements", controller.Placements)
+	http.HandleFunc("/multi", controller.Multi)
+	http.HandleFunc("/multi/vote", controller.MultiVote)
 	http.HandleFunc("/list", controller.List)
 	http.HandleFunc("/history", controller.History)
+	http.HandleFunc("/history/delete", controller.DeleteComparison)
+	http.HandleFunc("/history/flip", controller.FlipComparison)
+	http.HandleFunc("/recompute", controller.Recompute)
+	http.HandleFunc("/replay", controller.Replay)
+	http.HandleFunc("/tournaments", controller.Tournaments)
+	http.HandleFunc("/tournament/", controller.Tournament)
+	http.HandleFunc("/tournament/vote", controller.TournamentVote)
+	http.HandleFunc("/brackets", controller.Brackets)
+	http.HandleFunc("/bracket/", controller.Bracket)
+	http.HandleFunc("/bracket/vote", controller.BracketVote)
+	http.HandleFunc("/collections", controller.Collections)
 }
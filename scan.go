@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -12,27 +15,108 @@ import (
 	"sync"
 )
 
-var acceptedFileTypes = []string{
-	"jpg",
-	"jpeg",
-	"png",
-	"gif",
-	// "mp4",
-	// "webm",
+// mediaTypes are the accepted extensions, lower case, along with the
+// content type media is served as. An empty content type is detected
+// when serving.
+var mediaTypes = map[string]string{
+	"jpg": "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png": "image/png",
+	"gif": "image/gif",
+	"webp": "image/webp",
+	"avif": "image/avif",
+	"svg": "image/svg+xml",
+	"tif": "image/tiff",
+	"tiff": "image/tiff",
+	"heic": "image/heic",
+	"mp4": "video/mp4",
+	"webm": "video/webm",
+	"mov": "video/quicktime",
+	"mp3": "audio/mpeg",
+	"ogg": "audio/ogg",
+	"flac": "audio/flac",
+	"wav": "audio/wav",
+}
+
+// AddMediaTypes accepts extra extensions, given as a comma separated
+// list of ext or ext:content/type
+func AddMediaTypes(list string) error {
+	for _, entry := range(strings.Split(list, ",")) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ext, contentType, _ := strings.Cut(entry, ":")
+		ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+		if ext == "" || strings.ContainsAny(ext, "./") {
+			return fmt.Errorf("invalid extension in %q", entry)
+		}
+		if contentType != "" && !strings.Contains(contentType, "/") {
+			return fmt.Errorf("invalid content type in %q", entry)
+		}
+		mediaTypes[ext] = contentType
+	}
+	return nil
+}
+
+func mediaExt(path string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
 }
 
 func isMediaFile(path string) bool {
-	extDot := filepath.Ext(path)
-	if len(extDot) < 2 {
-		return false
+	_, ok := mediaTypes[mediaExt(path)]
+	return ok
+}
+
+// mediaContentType is the content type of path by its extension, or ""
+// if it has to be detected
+func mediaContentType(path string) string {
+	return mediaTypes[mediaExt(path)]
+}
+
+func isVideoFile(path string) bool {
+	return strings.HasPrefix(mediaContentType(path), "video/")
+}
+
+func isAudioFile(path string) bool {
+	return strings.HasPrefix(mediaContentType(path), "audio/")
+}
+
+// sniffImageFile reports whether the content of path is an image.
+// Files without an accepted extension are only shown as images, videos
+// and audio need their extension to be played.
+func sniffImageFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
 	}
-	ext := extDot[1:]
-	for _, allowed := range(acceptedFileTypes) {
-		if ext == allowed {
-			return true
-		}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	return strings.HasPrefix(http.DetectContentType(head[:n]), "image/"), nil
+}
+
+// maxSniffSize is the largest file without an accepted extension the
+// scan sniffs. Bigger ones are left out rather than read in full to be
+// hashed.
+const maxSniffSize = 64 << 20
+
+// sniffable reports whether the file at path, relative to the media
+// directory, is worth sniffing. The database and its journals never
+// are, nor are files over maxSniffSize.
+func sniffable(path string, d fs.DirEntry) (bool, error) {
+	if path == dbName || strings.HasPrefix(path, dbName + "-") {
+		return false, nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false, err
 	}
-	return false
+	return info.Size() <= maxSniffSize, nil
 }
 
 func scanMedia(ctx context.Context, server *Server, mediaPath string) (<-chan error, <-chan bool) {
@@ -42,6 +126,15 @@ func scanMedia(ctx context.Context, server *Server, mediaPath string) (<-chan er
 	ncpu := runtime.NumCPU()
 	var wg sync.WaitGroup
 
+	// Files already picked up by sniffing aren't sniffed again
+	sniffed, err := server.sniffedPaths()
+	if err != nil {
+		errChan <- fmt.Errorf("scanMedia: %w", err)
+		close(errChan)
+		close(finishChan)
+		return errChan, finishChan
+	}
+
 	// Mark files as deleted, files get unmarked when they appear in
 	// the scan by InsertMedia
 	if _, err := server.db.ExecContext(ctx, "UPDATE media SET deleted = true"); err != nil {
@@ -66,14 +159,34 @@ func scanMedia(ctx context.Context, server *Server, mediaPath string) (<-chan er
 				errChan <- fmt.Errorf("scanMedia WalkDirFunc: %w", err)
 				return nil
 			}
-			if d.IsDir() && strings.Contains(d.Name(), ".git") {
+			if d.IsDir() && (strings.Contains(d.Name(), ".git") || d.Name() == thumbnailDir) {
 				fmt.Printf("[#%s]", d.Name())
 				return filepath.SkipDir
 			} else if d.IsDir() {
 				fmt.Printf("[%s]", path)
 				return nil
-			} else if !isMediaFile(path) || !d.Type().IsRegular() {
+			} else if !d.Type().IsRegular() {
 				return nil
+			} else if !isMediaFile(path) && !sniffed[path] {
+				rel, err := filepath.Rel(mediaPath, path)
+				if err != nil {
+					errChan <- fmt.Errorf("scanMedia \"%s\": %w", path, err)
+					return nil
+				}
+				if ok, err := sniffable(rel, d); err != nil {
+					errChan <- fmt.Errorf("scanMedia stat \"%s\": %w", path, err)
+					return nil
+				} else if !ok {
+					return nil
+				}
+				image, err := sniffImageFile(path)
+				if err != nil {
+					errChan <- fmt.Errorf("scanMedia sniff \"%s\": %w", path, err)
+					return nil
+				}
+				if !image {
+					return nil
+				}
 			}
 
 			workChan <- path
@@ -109,9 +222,16 @@ func processMedia(server *Server, wg *sync.WaitGroup, workChan <-chan string, fi
 		if err != nil {
 			errChan <- fmt.Errorf("processMedia failed to insert scanned media: %w", err)
 			finishChan <- false
-		} else {
-			finishChan <- true
+			continue
+		}
+		if _, ok := thumbnailFile(path, sha1hex); ok && !isVideoFile(path) {
+			// a missing thumbnail is made again when it's requested,
+			// video posters only ever are
+			if _, err := makeThumbnail(server.thumbnails, path, sha1hex, fileData); err != nil {
+				errChan <- fmt.Errorf("processMedia failed to make thumbnail: %w", err)
+			}
 		}
+		finishChan <- true
 	}
 	wg.Done()
 }
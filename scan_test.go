@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMediaFileTypes(t *testing.T) {
+	cases := []struct {
+		path string
+		media bool
+		video bool
+		audio bool
+	}{
+		{ "photos/a.jpg", true, false, false },
+		{ "photos/b.gif", true, false, false },
+		{ "photos/IMG_001.JPG", true, false, false },
+		{ "photos/c.webp", true, false, false },
+		{ "photos/d.avif", true, false, false },
+		{ "photos/e.svg", true, false, false },
+		{ "photos/f.TIFF", true, false, false },
+		{ "photos/g.heic", true, false, false },
+		{ "clips/c.mp4", true, true, false },
+		{ "clips/d.webm", true, true, false },
+		{ "clips/E.MOV", true, true, false },
+		{ "sounds/g.mp3", true, false, true },
+		{ "sounds/h.ogg", true, false, true },
+		{ "sounds/i.FLAC", true, false, true },
+		{ "sounds/j.wav", true, false, true },
+		{ "notes/f.txt", false, false, false },
+		{ "clips/mp4", false, false, false },
+	}
+	for _, c := range(cases) {
+		if media := isMediaFile(c.path); media != c.media {
+			t.Errorf("isMediaFile(%q) = %t, expected %t", c.path, media, c.media)
+		}
+		if video := (MediaInfo{ Path: c.path }).IsVideo(); video != c.video {
+			t.Errorf("IsVideo of %q = %t, expected %t", c.path, video, c.video)
+		}
+		if audio := (MediaInfo{ Path: c.path }).IsAudio(); audio != c.audio {
+			t.Errorf("IsAudio of %q = %t, expected %t", c.path, audio, c.audio)
+		}
+	}
+}
+
+func TestAddMediaTypes(t *testing.T) {
+	defer func() {
+		delete(mediaTypes, "bmp")
+		delete(mediaTypes, "mkv")
+	}()
+	if err := AddMediaTypes("BMP, .mkv:video/x-matroska"); err != nil {
+		t.Fatalf("failed to add media types: %s", err)
+	}
+	if !isMediaFile("a.bmp") || mediaContentType("a.bmp") != "" {
+		t.Errorf("expected bmp to be accepted with a detected content type")
+	}
+	if !isVideoFile("b.mkv") {
+		t.Errorf("expected mkv to be accepted as video")
+	}
+	for _, invalid := range([]string{ "a/b", "jxl:jxl", ":image/png" }) {
+		if err := AddMediaTypes(invalid); err == nil {
+			t.Errorf("expected %q to be refused", invalid)
+		}
+	}
+}
+
+func TestSniffImageFile(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name string
+		content []byte
+		image bool
+	}{
+		{ "photo", []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR"), true },
+		{ "notes", []byte("just some text"), false },
+		{ "empty", nil, false },
+	}
+	for _, c := range(cases) {
+		path := filepath.Join(dir, c.name)
+		if err := os.WriteFile(path, c.content, 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", path, err)
+		}
+		image, err := sniffImageFile(path)
+		if err != nil {
+			t.Fatalf("failed to sniff %s: %s", path, err)
+		}
+		if image != c.image {
+			t.Errorf("sniffImageFile(%q) = %t, expected %t", c.name, image, c.image)
+		}
+	}
+}
+
+func TestScanSkipsUnsniffable(t *testing.T) {
+	dir := t.TempDir()
+	png := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+	for _, name := range([]string{ "photo", dbName, dbName + "-wal", "huge" }) {
+		// named in the content so each has its own sha1sum
+		if err := os.WriteFile(filepath.Join(dir, name), append(png, name...), 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+	if err := os.Truncate(filepath.Join(dir, "huge"), maxSniffSize + 1); err != nil {
+		t.Fatalf("failed to grow huge: %s", err)
+	}
+	// known no longer sniffs as an image, but was picked up before
+	known := filepath.Join(dir, "known")
+	if err := os.WriteFile(known, []byte("just some text"), 0644); err != nil {
+		t.Fatalf("failed to write known: %s", err)
+	}
+
+	s := newServer(":memory:", t)
+	insertMedia(s, known, "known", t)
+	errChan, finishChan := scanMedia(context.Background(), s, dir)
+	done := make(chan bool)
+	go func() {
+		for err := range(errChan) {
+			t.Errorf("scan failed: %s", err)
+		}
+		close(done)
+	}()
+	for range(finishChan) {
+	}
+	<-done
+	if _, err := s.RemoveDeletedMedia(); err != nil {
+		t.Fatalf("failed to remove deleted media: %s", err)
+	}
+
+	paths, err := s.sniffedPaths()
+	if err != nil {
+		t.Fatalf("failed to get sniffed paths: %s", err)
+	}
+	expected := map[string]bool{ filepath.Join(dir, "photo"): true, known: true }
+	if fmt.Sprint(paths) != fmt.Sprint(expected) {
+		t.Errorf("expected %v to be scanned, found %v", expected, paths)
+	}
+}
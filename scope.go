@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Scope limits the face-off, ranked list and history to part of the
+// library: a path prefix, a glob matched against the path, a tag, or
+// a collection. Empty fields don't filter.
+type Scope struct {
+	Prefix string
+	Glob string
+	Tag string
+	// Collection also switches to the ratings in that collection
+	Collection int64
+}
+
+// ParseScope reads a scope from the prefix, glob, tag and collection
+// query parameters
+func ParseScope(query url.Values) Scope {
+	collection, _ := strconv.ParseInt(query.Get("collection"), 10, 64)
+	return Scope{
+		Prefix: query.Get("prefix"),
+		Glob: query.Get("glob"),
+		Tag: query.Get("tag"),
+		Collection: collection,
+	}
+}
+
+func (sc Scope) IsZero() bool {
+	return sc == Scope{}
+}
+
+// Query encodes the scope as query parameters
+func (sc Scope) Query() url.Values {
+	query := url.Values{}
+	if sc.Prefix != "" {
+		query.Set("prefix", sc.Prefix)
+	}
+	if sc.Glob != "" {
+		query.Set("glob", sc.Glob)
+	}
+	if sc.Tag != "" {
+		query.Set("tag", sc.Tag)
+	}
+	if sc.Collection != 0 {
+		query.Set("collection", strconv.FormatInt(sc.Collection, 10))
+	}
+	return query
+}
+
+func (sc Scope) String() string {
+	var parts []string
+	if sc.Prefix != "" {
+		parts = append(parts, fmt.Sprintf("in %s", sc.Prefix))
+	}
+	if sc.Glob != "" {
+		parts = append(parts, fmt.Sprintf("matching %s", sc.Glob))
+	}
+	if sc.Tag != "" {
+		parts = append(parts, fmt.Sprintf("tagged %s", sc.Tag))
+	}
+	if sc.Collection != 0 {
+		parts = append(parts, fmt.Sprintf("collection %d", sc.Collection))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// board is the leaderboard ratings in scope are read from and written to
+func (sc Scope) board() leaderboard {
+	return leaderboard(sc.Collection)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// where returns an SQL condition on the media table aliased table that
+// holds for media in scope, along with its arguments
+func (sc Scope) where(table string) (string, []any) {
+	conditions := []string{ "true" }
+	var args []any
+	if sc.Prefix != "" {
+		conditions = append(conditions, table + `.path LIKE ? ESCAPE '\'`)
+		args = append(args, likeEscaper.Replace(sc.Prefix) + "%")
+	}
+	if sc.Glob != "" {
+		conditions = append(conditions, table + ".path GLOB ?")
+		args = append(args, sc.Glob)
+	}
+	if sc.Tag != "" {
+		conditions = append(conditions, table + ".id IN (SELECT media_id FROM media_tags WHERE tag = ?)")
+		args = append(args, sc.Tag)
+	}
+	if sc.Collection != 0 {
+		conditions = append(conditions, table + ".id IN (SELECT media_id FROM collection_ratings WHERE collection_id = ?)")
+		args = append(args, sc.Collection)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// scopeSampleFactor is how many times more media than asked for are
+// sampled from the library when looking for media in a scope
+const scopeSampleFactor = 8
+
+// mediaInScope returns the media out of ids that are in scope, rated on
+// the scope's leaderboard, in no particular order
+func (s *Server) mediaInScope(scope Scope, ids []int64) ([]MediaInfo, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	where, args := scope.where("m")
+	placeholders := strings.Repeat(", ?", len(ids))[2:]
+	idArgs := make([]any, len(ids))
+	for i, id := range(ids) {
+		idArgs[i] = id
+	}
+	rows, err := s.db.Query(scope.board().ratingsQuery() + " AND m.id IN (" + placeholders + ") AND " + where, append(idArgs, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("mediaInScope query failed: %w", err)
+	}
+	return scanMediaList(rows, len(ids))
+}
+
+// randomMediaInScope returns up to n media in scope picked at random,
+// ordering the whole scope at random
+func (s *Server) randomMediaInScope(scope Scope, n int) ([]MediaInfo, error) {
+	where, args := scope.where("ratings")
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM (%s) ratings WHERE %s ORDER BY RANDOM() LIMIT ?", mediaColumns, scope.board().ratingsQuery(), where), append(args, n)...)
+	if err != nil {
+		return nil, fmt.Errorf("randomMediaInScope query failed: %w", err)
+	}
+	return scanMediaList(rows, n)
+}
+
+// TagMedia tags every media whose path matches glob and returns how
+// many were tagged
+func (s *Server) TagMedia(tag, glob string) (int64, error) {
+	result, err := s.db.Exec("INSERT OR IGNORE INTO media_tags(media_id, tag) SELECT id, ? FROM media WHERE path GLOB ?", tag, glob)
+	if err != nil {
+		return 0, fmt.Errorf("TagMedia insert failed: %w", err)
+	}
+	tagged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("TagMedia rows affected: %w", err)
+	}
+	return tagged, nil
+}
+
+// Tags returns every tag in use, sorted
+func (s *Server) Tags() ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT tag FROM media_tags ORDER BY tag")
+	if err != nil {
+		return nil, fmt.Errorf("Tags query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("Tags scan row: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("Tags rows: %w", rows.Err())
+	}
+	return tags, nil
+}
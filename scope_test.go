@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	s := newServer(":memory:", t)
+	event1 := insertMedia(s, "events/2023/a.jpg", "a", t)
+	event2 := insertMedia(s, "events/2023/b.png", "b", t)
+	product := insertMedia(s, "products/c.jpg", "c", t)
+	underscore := insertMedia(s, "events_old/d.jpg", "d", t)
+	updateScores(s, event1, event2, t)
+	updateScores(s, event1, product, t)
+
+	ids := func(list []MediaInfo) map[int64]bool {
+		found := make(map[int64]bool, len(list))
+		for _, media := range(list) {
+			found[media.Id] = true
+		}
+		return found
+	}
+
+	t.Run("prefix", func(t *testing.T) {
+		list, err := s.SortedList(true, Scope{ Prefix: "events/" })
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		found := ids(list)
+		if len(list) != 2 || !found[event1] || !found[event2] {
+			t.Errorf("expected only the events media, found %+v", list)
+		}
+		// _ is a LIKE wildcard and has to be matched literally
+		list, err = s.SortedList(true, Scope{ Prefix: "events_" })
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		if len(list) != 1 || list[0].Id != underscore {
+			t.Errorf("expected only %d, found %+v", underscore, list)
+		}
+	})
+
+	t.Run("glob", func(t *testing.T) {
+		list, err := s.SortedList(true, Scope{ Glob: "*.jpg" })
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		if found := ids(list); len(list) != 3 || found[event2] {
+			t.Errorf("expected only the jpg media, found %+v", list)
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		tagged, err := s.TagMedia("shop", "products/*")
+		if err != nil {
+			t.Fatalf("failed to tag media: %s", err)
+		}
+		if tagged != 1 {
+			t.Errorf("expected 1 media tagged, found %d", tagged)
+		}
+		list, err := s.SortedList(true, Scope{ Tag: "shop" })
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		if len(list) != 1 || list[0].Id != product {
+			t.Errorf("expected only %d, found %+v", product, list)
+		}
+	})
+
+	t.Run("history only holds comparisons within the scope", func(t *testing.T) {
+		comparisons, err := s.Comparisons(Scope{ Prefix: "events/" })
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if len(comparisons) != 1 || comparisons[0].Loser.Id != event2 {
+			t.Errorf("expected only the comparison between events, found %+v", comparisons)
+		}
+	})
+
+	t.Run("face-off", func(t *testing.T) {
+		// every selection strategy keeps to the scope
+		for name := range(selectors) {
+			selector, err := NewSelector(name)
+			if err != nil {
+				t.Fatalf("failed to make selector: %s", err)
+			}
+			s.selector = selector
+			for i := 0; i < 10; i++ {
+				media1, media2, err := s.SelectMediaForComparison(SelectOptions{ Scope: Scope{ Prefix: "events/" } })
+				if err != nil {
+					t.Fatalf("%s failed to select media for comparison: %s", name, err)
+				}
+				found := ids([]MediaInfo{ media1, media2 })
+				if !found[event1] || !found[event2] {
+					t.Errorf("expected %s to pick the events media, found %d and %d", name, media1.Id, media2.Id)
+				}
+			}
+			_, _, err = s.SelectMediaForComparison(SelectOptions{ Scope: Scope{ Tag: "shop" } })
+			if !errors.Is(err, NotEnoughMediaError) {
+				t.Errorf("expected %s to find NotEnoughMediaError with one media in scope, found %v", name, err)
+			}
+		}
+	})
+}
+
+func TestScopeSpread(t *testing.T) {
+	s := newServer(":memory:", t)
+	var inScope []int64
+	for i := 0; i < 1000; i++ {
+		folder := "other/"
+		if i % 2 == 0 {
+			folder = "events/"
+		}
+		id := insertMedia(s, fmt.Sprintf("%s%d.jpg", folder, i), fmt.Sprint(i), t)
+		if i % 2 == 0 {
+			inScope = append(inScope, id)
+		}
+	}
+	decile := make(map[int64]int, len(inScope))
+	for i, id := range(inScope) {
+		decile[id] = i * 10 / len(inScope)
+	}
+
+	// every part of the scope is offered, not only the lowest ids
+	picks := make([]int, 10)
+	for i := 0; i < 500; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{ Scope: Scope{ Prefix: "events/" } })
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		for _, media := range([]MediaInfo{ media1, media2 }) {
+			d, ok := decile[media.Id]
+			if !ok {
+				t.Fatalf("expected media in scope, found %s", media.Path)
+			}
+			picks[d]++
+		}
+	}
+	for _, count := range(picks) {
+		if count < 40 {
+			t.Errorf("expected about 100 picks in every tenth of the scope, found %v", picks)
+			break
+		}
+	}
+}
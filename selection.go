@@ -0,0 +1,361 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Selector picks the next two media to compare on the face-off page.
+// Server.SelectMediaForComparison delegates to a Selector.
+type Selector interface {
+	// SelectPair returns the ids of two different media in scope.
+	SelectPair(s *Server, scope Scope) (int64, int64, error)
+}
+
+var selectors = map[string]func() Selector{
+	"random": func() Selector { return RandomSelector{} },
+	"active": func() Selector { return NewActiveSelector() },
+	"coverage": func() Selector { return NewCoverageSelector() },
+	"top": func() Selector { return NewTopSelector() },
+}
+
+// NewSelector returns the selection strategy registered under name.
+func NewSelector(name string) (Selector, error) {
+	newSelector, ok := selectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown selection strategy \"%s\", expected one of: %s", name, selectorNames())
+	}
+	return newSelector(), nil
+}
+
+func selectorNames() string {
+	names := make([]string, 0, len(selectors))
+	for name := range(selectors) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// SelectOptions narrows down which pair SelectMediaForComparison may
+// pick.
+type SelectOptions struct {
+	// Session identifies the browser asking, pairs served to it
+	// recently are avoided.
+	Session string
+	// Top, if set, draws both media from the Top highest ranked
+	// instead of using the server's selector.
+	Top int
+	// Band, if set, draws both media from a score band instead.
+	Band *ScoreBand
+	// Scope limits both media to part of the library, whichever
+	// selector picks them
+	Scope Scope
+}
+
+// ScoreBand is a range of scores, inclusive
+type ScoreBand struct {
+	Min int
+	Max int
+}
+
+// selector returns the selector the options call for
+func (o SelectOptions) selector(s *Server) Selector {
+	if o.Top > 0 {
+		return &TopSelector{ K: o.Top, PoolSize: defaultPoolSize }
+	}
+	if o.Band != nil {
+		return &BandSelector{ ScoreBand: *o.Band, PoolSize: defaultPoolSize }
+	}
+	return s.selector
+}
+
+// maxRecentSessions is how many sessions recentMemory keeps, the ones
+// least recently served are forgotten first
+const maxRecentSessions = 1000
+
+// recentMemory remembers the last pairs served so they aren't offered
+// again right away. Each session has its own memory unless global.
+type recentMemory struct {
+	mutex sync.Mutex
+	// itemWindow is how many of the latest pairs no item is repeated
+	// from.
+	itemWindow int
+	// pairWindow is how many of the latest pairs aren't repeated.
+	pairWindow int
+	global bool
+	maxSessions int
+	served map[string]*list.Element
+	// sessions orders the served sessions, most recently served first
+	sessions *list.List
+}
+
+type recentSession struct {
+	key string
+	served [][2]int64
+}
+
+func newRecentMemory(itemWindow, pairWindow int, global bool) *recentMemory {
+	return &recentMemory{
+		itemWindow: itemWindow,
+		pairWindow: pairWindow,
+		global: global,
+		maxSessions: maxRecentSessions,
+		served: make(map[string]*list.Element),
+		sessions: list.New(),
+	}
+}
+
+func (m *recentMemory) key(session string) string {
+	if m.global {
+		return ""
+	}
+	return session
+}
+
+// Remember records that the pair was served to session.
+func (m *recentMemory) Remember(session string, id1, id2 int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := m.key(session)
+	element, ok := m.served[key]
+	if ok {
+		m.sessions.MoveToFront(element)
+	} else {
+		element = m.sessions.PushFront(&recentSession{ key: key })
+		m.served[key] = element
+		for m.sessions.Len() > m.maxSessions {
+			oldest := m.sessions.Back()
+			m.sessions.Remove(oldest)
+			delete(m.served, oldest.Value.(*recentSession).key)
+		}
+	}
+
+	entry := element.Value.(*recentSession)
+	served := append(entry.served, [2]int64{ id1, id2 })
+	keep := m.itemWindow
+	if m.pairWindow > keep {
+		keep = m.pairWindow
+	}
+	if len(served) > keep {
+		served = served[len(served) - keep:]
+	}
+	entry.served = served
+}
+
+// Recent reports whether the pair, or either of its items, was served
+// to session within the window.
+func (m *recentMemory) Recent(session string, id1, id2 int64) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	element, ok := m.served[m.key(session)]
+	if !ok {
+		return false
+	}
+	served := element.Value.(*recentSession).served
+	for age := 1; age <= len(served); age++ {
+		pair := served[len(served) - age]
+		if age <= m.pairWindow && ((pair[0] == id1 && pair[1] == id2) || (pair[0] == id2 && pair[1] == id1)) {
+			return true
+		}
+		if age <= m.itemWindow && (pair[0] == id1 || pair[1] == id1 || pair[0] == id2 || pair[1] == id2) {
+			return true
+		}
+	}
+	return false
+}
+
+// RandomSelector picks two media uniformly at random.
+type RandomSelector struct{}
+
+func (RandomSelector) SelectPair(s *Server, scope Scope) (int64, int64, error) {
+	return s.randomPair(scope)
+}
+
+// ActiveSelector spends votes where they teach the most. It draws a
+// random pool of candidates and picks the pair whose outcome is least
+// predictable, weighted towards media the rater is unsure about or
+// that have few matches.
+type ActiveSelector struct {
+	// PoolSize is how many random candidates are considered.
+	PoolSize int
+}
+
+// defaultPoolSize is how many candidates selectors weigh by default
+const defaultPoolSize = 24
+
+func NewActiveSelector() *ActiveSelector {
+	return &ActiveSelector{ PoolSize: defaultPoolSize }
+}
+
+func (a *ActiveSelector) SelectPair(s *Server, scope Scope) (int64, int64, error) {
+	pool, err := s.randomMedia(scope, a.PoolSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return mostInformativePair(s.rater, pool)
+}
+
+// mostInformativePair returns the pair out of pool with the highest
+// informationGain.
+func mostInformativePair(rater Rater, pool []MediaInfo) (int64, int64, error) {
+	if len(pool) < 2 {
+		return 0, 0, NotEnoughMediaError
+	}
+
+	best1, best2 := pool[0], pool[1]
+	bestGain := -1.0
+	for i := range(pool) {
+		for j := i + 1; j < len(pool); j++ {
+			gain := informationGain(rater, pool[i], pool[j])
+			if gain > bestGain {
+				best1, best2, bestGain = pool[i], pool[j], gain
+			}
+		}
+	}
+
+	return best1.Id, best2.Id, nil
+}
+
+// informationGain estimates how much comparing media1 and media2 would
+// improve the ranking. Close scores make the outcome uncertain, and
+// uncertain or rarely compared media have the most to learn.
+func informationGain(rater Rater, media1, media2 MediaInfo) float64 {
+	expected := 1 / (1 + math.Pow(10, float64(media2.Score - media1.Score) / 400))
+	outcomeUncertainty := expected * (1 - expected)
+	return outcomeUncertainty * (ratingUncertainty(rater, media1) + ratingUncertainty(rater, media2))
+}
+
+// ratingUncertainty is between 0 and 1, 1 for media that was never
+// compared.
+func ratingUncertainty(rater Rater, media MediaInfo) float64 {
+	uncertainty := 1 / math.Sqrt(float64(media.Matches + 1))
+	if tracker, ok := rater.(uncertaintyRater); ok {
+		initial := tracker.Confidence(resetRating(media, media.Score))
+		if initial > 0 {
+			uncertainty *= math.Min(tracker.Confidence(media) / initial, 1)
+		}
+	}
+	return uncertainty
+}
+
+// CoverageSelector makes sure every media gets compared. Media with
+// fewer than MinMatches matches are offered first, and media is
+// picked with a weight inversely proportional to its match count.
+type CoverageSelector struct {
+	// MinMatches is the number of matches every media should reach
+	// before well sampled media are offered again.
+	MinMatches int
+	// PoolSize is how many random candidates are weighed.
+	PoolSize int
+}
+
+func NewCoverageSelector() *CoverageSelector {
+	return &CoverageSelector{ MinMatches: 5, PoolSize: defaultPoolSize }
+}
+
+func (c *CoverageSelector) SelectPair(s *Server, scope Scope) (int64, int64, error) {
+	pool, err := s.randomMediaUnder(scope, c.MinMatches, c.PoolSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(pool) >= 2 {
+		return pickPairByMatches(pool)
+	}
+
+	rest, err := s.randomMedia(scope, c.PoolSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(pool) == 0 {
+		return pickPairByMatches(rest)
+	}
+
+	// Only one media is under the minimum, find it an opponent
+	opponents := make([]MediaInfo, 0, len(rest))
+	for _, media := range(rest) {
+		if media.Id != pool[0].Id {
+			opponents = append(opponents, media)
+		}
+	}
+	if len(opponents) == 0 {
+		return 0, 0, NotEnoughMediaError
+	}
+	return pool[0].Id, opponents[pickByMatches(opponents)].Id, nil
+}
+
+func pickPairByMatches(pool []MediaInfo) (int64, int64, error) {
+	if len(pool) < 2 {
+		return 0, 0, NotEnoughMediaError
+	}
+	first := pickByMatches(pool)
+	last := len(pool) - 1
+	pool[first], pool[last] = pool[last], pool[first]
+	second := pickByMatches(pool[:last])
+	return pool[last].Id, pool[second].Id, nil
+}
+
+// pickByMatches returns the index of a random media in pool, weighted
+// inversely by its match count.
+func pickByMatches(pool []MediaInfo) int {
+	var total float64
+	for _, media := range(pool) {
+		total += 1 / float64(media.Matches + 1)
+	}
+	target := rand.Float64() * total
+	for i, media := range(pool) {
+		target -= 1 / float64(media.Matches + 1)
+		if target < 0 {
+			return i
+		}
+	}
+	return len(pool) - 1
+}
+
+// TopSelector spends votes on the head of the ranking. Both media come
+// from the K highest ranked, and out of a random pool of those the
+// most informative pair is picked.
+type TopSelector struct {
+	K int
+	// PoolSize is how many of the top K candidates are weighed.
+	PoolSize int
+}
+
+func NewTopSelector() *TopSelector {
+	return &TopSelector{ K: 50, PoolSize: defaultPoolSize }
+}
+
+func (t *TopSelector) SelectPair(s *Server, scope Scope) (int64, int64, error) {
+	top, err := s.topMedia(scope, t.K)
+	if err != nil {
+		return 0, 0, err
+	}
+	rand.Shuffle(len(top), func(i, j int) { top[i], top[j] = top[j], top[i] })
+	if len(top) > t.PoolSize {
+		top = top[:t.PoolSize]
+	}
+	return mostInformativePair(s.rater, top)
+}
+
+// BandSelector draws both media from a score band, picking the most
+// informative pair out of a random pool.
+type BandSelector struct {
+	ScoreBand
+	// PoolSize is how many candidates in the band are weighed.
+	PoolSize int
+}
+
+func (b *BandSelector) SelectPair(s *Server, scope Scope) (int64, int64, error) {
+	pool, err := s.randomMediaInBand(scope, b.Min, b.Max, b.PoolSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return mostInformativePair(s.rater, pool)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewSelector(t *testing.T) {
+	selector, err := NewSelector("active")
+	if err != nil {
+		t.Fatalf("failed to create active selector: %s", err)
+	}
+	if _, ok := selector.(*ActiveSelector); !ok {
+		t.Errorf("expected active to return *ActiveSelector, found %T", selector)
+	}
+	if _, err := NewSelector("nonexistent"); err == nil {
+		t.Error("expected unknown selection strategy to return an error")
+	}
+}
+
+func TestInformationGain(t *testing.T) {
+	rater := NewEloRater()
+	close1 := MediaInfo{ Score: 1500, Matches: 10 }
+	close2 := MediaInfo{ Score: 1520, Matches: 10 }
+	far := MediaInfo{ Score: 1900, Matches: 10 }
+	fresh := MediaInfo{ Score: 1520, Matches: 0 }
+
+	if informationGain(rater, close1, close2) <= informationGain(rater, close1, far) {
+		t.Error("expected close scores to be worth more than an obvious outcome")
+	}
+	if informationGain(rater, close1, fresh) <= informationGain(rater, close1, close2) {
+		t.Error("expected media with fewer matches to be worth more")
+	}
+
+	glicko := NewGlicko2Rater()
+	sure := MediaInfo{ Score: 1520, Matches: 10, Deviation: 60, Volatility: 0.06 }
+	unsure := MediaInfo{ Score: 1520, Matches: 10, Deviation: 300, Volatility: 0.06 }
+	if informationGain(glicko, close1, unsure) <= informationGain(glicko, close1, sure) {
+		t.Error("expected media with a higher deviation to be worth more")
+	}
+}
+
+func TestActiveSelector(t *testing.T) {
+	s := newServer(":memory:", t)
+	s.selector = NewActiveSelector()
+	id1 := insertMedia(s, "a", "aaa", t)
+	id2 := insertMedia(s, "b", "bbb", t)
+	id3 := insertMedia(s, "c", "ccc", t)
+	if _, err := s.db.Exec("UPDATE media SET score = 2500 WHERE id = ?", id3); err != nil {
+		t.Fatalf("failed to set score: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{})
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		if !(media1.Id == id1 && media2.Id == id2) && !(media1.Id == id2 && media2.Id == id1) {
+			t.Errorf("expected the two close media to be picked, found %d and %d", media1.Id, media2.Id)
+		}
+	}
+}
+
+func TestCoverageSelector(t *testing.T) {
+	s := newServer(":memory:", t)
+	s.selector = &CoverageSelector{ MinMatches: 2, PoolSize: 24 }
+	id1 := insertMedia(s, "a", "aaa", t)
+	id2 := insertMedia(s, "b", "bbb", t)
+	id3 := insertMedia(s, "c", "ccc", t)
+	id4 := insertMedia(s, "d", "ddd", t)
+	updateScores(s, id1, id2, t)
+	updateScores(s, id1, id2, t)
+
+	covered, total, err := s.CoverageProgress(2)
+	if err != nil {
+		t.Fatalf("failed to get coverage progress: %s", err)
+	}
+	if covered != 2 || total != 4 {
+		t.Errorf("expected 2 of 4 media covered, found %d of %d", covered, total)
+	}
+
+	for i := 0; i < 10; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{})
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		if !(media1.Id == id3 && media2.Id == id4) && !(media1.Id == id4 && media2.Id == id3) {
+			t.Errorf("expected the two uncompared media to be picked, found %d and %d", media1.Id, media2.Id)
+		}
+	}
+
+	// With only one media under the minimum it gets paired with another
+	updateScores(s, id3, id1, t)
+	updateScores(s, id3, id2, t)
+	for i := 0; i < 10; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{})
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		if media1.Id == media2.Id {
+			t.Errorf("returned two copies of the same media: %d", media1.Id)
+		}
+		if media1.Id != id4 && media2.Id != id4 {
+			t.Errorf("expected uncompared media %d to be picked, found %d and %d", id4, media1.Id, media2.Id)
+		}
+	}
+}
+
+func TestCoverageSelectorSpread(t *testing.T) {
+	s := newServer(":memory:", t)
+	s.selector = NewCoverageSelector()
+	var ids []int64
+	for i := 0; i < 1000; i++ {
+		ids = append(ids, insertMedia(s, fmt.Sprint(i), fmt.Sprint(i), t))
+	}
+
+	// every media is under the minimum, so all of it is offered
+	// evenly rather than the lowest ids first
+	picks := make([]int, 10)
+	for i := 0; i < 500; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{})
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		picks[int(media1.Id - ids[0]) * 10 / len(ids)]++
+		picks[int(media2.Id - ids[0]) * 10 / len(ids)]++
+	}
+	for _, count := range(picks) {
+		if count < 40 {
+			t.Errorf("expected about 100 picks in every tenth of the library, found %v", picks)
+			break
+		}
+	}
+}
+
+func TestTopAndBandSelection(t *testing.T) {
+	s := newServer(":memory:", t)
+	for i := 0; i < 20; i++ {
+		id := insertMedia(s, fmt.Sprintf("%d", i), fmt.Sprintf("%d", i), t)
+		if _, err := s.db.Exec("UPDATE media SET score = ? WHERE id = ?", 1000 + i * 100, id); err != nil {
+			t.Fatalf("failed to set score: %s", err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{ Top: 4 })
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		if media1.Id == media2.Id {
+			t.Errorf("returned two copies of the same media: %d", media1.Id)
+		}
+		if media1.Score < 2600 || media2.Score < 2600 {
+			t.Errorf("expected media from the top 4, found scores %d and %d", media1.Score, media2.Score)
+		}
+	}
+
+	band := &ScoreBand{ Min: 1500, Max: 1800 }
+	for i := 0; i < 20; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{ Band: band })
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		for _, media := range([]MediaInfo{ media1, media2 }) {
+			if media.Score < band.Min || media.Score > band.Max {
+				t.Errorf("expected media scored between %d and %d, found %d", band.Min, band.Max, media.Score)
+			}
+		}
+	}
+
+	if _, _, err := s.SelectMediaForComparison(SelectOptions{ Band: &ScoreBand{ Min: 5000, Max: 6000 } }); !errors.Is(err, NotEnoughMediaError) {
+		t.Errorf("expected NotEnoughMediaError for an empty band, found %v", err)
+	}
+}
+
+func TestPickByMatches(t *testing.T) {
+	pool := []MediaInfo{{ Matches: 99 }, { Matches: 0 }}
+	picks := make([]int, 2)
+	for i := 0; i < 1000; i++ {
+		picks[pickByMatches(pool)]++
+	}
+	if picks[1] < picks[0] * 10 {
+		t.Errorf("expected media with no matches to be picked far more often, picked %v", picks)
+	}
+}
+
+func TestRecentMemory(t *testing.T) {
+	memory := newRecentMemory(1, 3, false)
+	memory.Remember("a", 1, 2)
+	if !memory.Recent("a", 2, 1) {
+		t.Error("expected the last pair to be recent in either order")
+	}
+	if !memory.Recent("a", 1, 5) {
+		t.Error("expected an item from the last pair to be recent")
+	}
+	if memory.Recent("b", 1, 2) {
+		t.Error("expected another session not to share the memory")
+	}
+
+	memory.Remember("a", 3, 4)
+	if memory.Recent("a", 1, 5) {
+		t.Error("expected the item window to have passed")
+	}
+	if !memory.Recent("a", 1, 2) {
+		t.Error("expected the pair to still be in the pair window")
+	}
+	memory.Remember("a", 5, 6)
+	memory.Remember("a", 7, 8)
+	if memory.Recent("a", 1, 2) {
+		t.Error("expected the pair window to have passed")
+	}
+
+	global := newRecentMemory(1, 1, true)
+	global.Remember("a", 1, 2)
+	if !global.Recent("b", 1, 2) {
+		t.Error("expected global memory to be shared between sessions")
+	}
+
+	capped := newRecentMemory(1, 1, false)
+	capped.maxSessions = 2
+	capped.Remember("a", 1, 2)
+	capped.Remember("b", 1, 2)
+	capped.Remember("a", 3, 4)
+	capped.Remember("c", 1, 2)
+	if len(capped.served) != 2 || capped.sessions.Len() != 2 {
+		t.Errorf("expected 2 sessions to be kept, found %d", len(capped.served))
+	}
+	if capped.Recent("b", 1, 2) {
+		t.Error("expected the least recently served session to be forgotten")
+	}
+	if !capped.Recent("a", 3, 4) {
+		t.Error("expected a recently served session to be kept")
+	}
+}
+
+func TestSelectMediaForComparisonAvoidsRecent(t *testing.T) {
+	s := newServer(":memory:", t)
+	s.recent = newRecentMemory(1, 1, false)
+	for i := 0; i < 6; i++ {
+		name := string(rune('a' + i))
+		insertMedia(s, name, name, t)
+	}
+
+	// With 6 media a random pair shares an item with the previous
+	// one 60% of the time, retrying makes it rare
+	var last1, last2 int64
+	repeats := 0
+	for i := 0; i < 50; i++ {
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{ Session: "test" })
+		if err != nil {
+			t.Fatalf("failed to select media for comparison: %s", err)
+		}
+		if media1.Id == last1 || media1.Id == last2 || media2.Id == last1 || media2.Id == last2 {
+			repeats++
+		}
+		last1, last2 = media1.Id, media2.Id
+	}
+	if repeats > 5 {
+		t.Errorf("expected items from the previous round to rarely repeat, repeated %d/50", repeats)
+	}
+}
@@ -4,7 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"math"
+	"math/rand"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -15,6 +16,20 @@ type MediaInfo struct {
 	Sha1 string `json:"sha1"`
 	Score int   `json:"score"`
 	Matches int `json:"matches"`
+	Deviation float64  `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+	Mu float64         `json:"mu"`
+	Sigma float64      `json:"sigma"`
+}
+
+// IsVideo reports whether the media is played back rather than shown
+func (m MediaInfo) IsVideo() bool {
+	return isVideoFile(m.Path)
+}
+
+// IsAudio reports whether the media is only listened to
+func (m MediaInfo) IsAudio() bool {
+	return isAudioFile(m.Path)
 }
 
 const schema = `
@@ -35,9 +50,158 @@ CREATE TABLE IF NOT EXISTS comparisons (
   FOREIGN KEY(winner_id) REFERENCES media(id) ON DELETE CASCADE,
   FOREIGN KEY(loser_id) REFERENCES media(id) ON DELETE CASCADE
 );
+CREATE INDEX IF NOT EXISTS media_matches_idx ON media(matches);
+CREATE INDEX IF NOT EXISTS media_score_idx ON media(score);
+
 CREATE INDEX IF NOT EXISTS comparisons_winner_id_idx ON comparisons(winner_id);
 CREATE INDEX IF NOT EXISTS comparisons_loser_id_idx ON comparisons(loser_id);
 
+-- How the global scores were last rebuilt from the history, so edits
+-- to the history rebuild them the same way
+CREATE TABLE IF NOT EXISTS score_basis (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  start INTEGER NOT NULL,
+  bradley_terry BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS skips (
+  id INTEGER PRIMARY KEY,
+  media1_id INTEGER NOT NULL,
+  media2_id INTEGER NOT NULL,
+  FOREIGN KEY(media1_id) REFERENCES media(id) ON DELETE CASCADE,
+  FOREIGN KEY(media2_id) REFERENCES media(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS skips_media1_id_idx ON skips(media1_id);
+CREATE INDEX IF NOT EXISTS skips_media2_id_idx ON skips(media2_id);
+
+CREATE TABLE IF NOT EXISTS media_tags (
+  media_id INTEGER NOT NULL,
+  tag TEXT NOT NULL,
+  PRIMARY KEY(media_id, tag),
+  FOREIGN KEY(media_id) REFERENCES media(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS media_tags_tag_idx ON media_tags(tag);
+
+CREATE TABLE IF NOT EXISTS collections (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  prefix TEXT NOT NULL DEFAULT '',
+  glob TEXT NOT NULL DEFAULT '',
+  tag TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS collection_ratings (
+  collection_id INTEGER NOT NULL,
+  media_id INTEGER NOT NULL,
+  score INTEGER NOT NULL,
+  matches INTEGER NOT NULL DEFAULT 0,
+  deviation REAL NOT NULL DEFAULT 350,
+  volatility REAL NOT NULL DEFAULT 0.06,
+  mu REAL NOT NULL DEFAULT 1500,
+  sigma REAL NOT NULL DEFAULT 500,
+  PRIMARY KEY(collection_id, media_id),
+  FOREIGN KEY(collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+  FOREIGN KEY(media_id) REFERENCES media(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS placements (
+  media_id INTEGER PRIMARY KEY,
+  position INTEGER NOT NULL,
+  -- bounds of the binary search over the ranked anchors
+  low INTEGER NOT NULL,
+  high INTEGER NOT NULL,
+  steps INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY(media_id) REFERENCES media(id) ON DELETE CASCADE
+);
+
+-- Placement of media before each placement vote, so undo can put it
+-- back, and the score the vote that finished it placed it at
+CREATE TABLE IF NOT EXISTS placement_steps (
+  comparison_id INTEGER PRIMARY KEY,
+  media_id INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  low INTEGER NOT NULL,
+  high INTEGER NOT NULL,
+  steps INTEGER NOT NULL,
+  placed_score INTEGER,
+  FOREIGN KEY(comparison_id) REFERENCES comparisons(id) ON DELETE CASCADE,
+  FOREIGN KEY(media_id) REFERENCES media(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tournaments (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  rounds INTEGER NOT NULL,
+  finished INTEGER NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS tournament_entries (
+  tournament_id INTEGER NOT NULL,
+  media_id INTEGER NOT NULL,
+  PRIMARY KEY(tournament_id, media_id),
+  FOREIGN KEY(tournament_id) REFERENCES tournaments(id) ON DELETE CASCADE,
+  FOREIGN KEY(media_id) REFERENCES media(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tournament_rounds (
+  id INTEGER PRIMARY KEY,
+  tournament_id INTEGER NOT NULL,
+  number INTEGER NOT NULL,
+  bye_media_id INTEGER,
+  UNIQUE(tournament_id, number),
+  FOREIGN KEY(tournament_id) REFERENCES tournaments(id) ON DELETE CASCADE,
+  FOREIGN KEY(bye_media_id) REFERENCES media(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS tournament_matches (
+  id INTEGER PRIMARY KEY,
+  round_id INTEGER NOT NULL,
+  media1_id INTEGER NOT NULL,
+  media2_id INTEGER NOT NULL,
+  -- NULL until played, on a draw winner_id is media1_id
+  winner_id INTEGER,
+  outcome INTEGER,
+  comparison_id INTEGER,
+  FOREIGN KEY(round_id) REFERENCES tournament_rounds(id) ON DELETE CASCADE,
+  FOREIGN KEY(media1_id) REFERENCES media(id) ON DELETE CASCADE,
+  FOREIGN KEY(media2_id) REFERENCES media(id) ON DELETE CASCADE,
+  FOREIGN KEY(comparison_id) REFERENCES comparisons(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS tournament_matches_round_id_idx ON tournament_matches(round_id);
+
+CREATE TABLE IF NOT EXISTS brackets (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  double_elimination INTEGER NOT NULL DEFAULT false,
+  entrants INTEGER NOT NULL,
+  champion_id INTEGER,
+  FOREIGN KEY(champion_id) REFERENCES media(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS bracket_matches (
+  id INTEGER PRIMARY KEY,
+  bracket_id INTEGER NOT NULL,
+  number INTEGER NOT NULL,
+  section INTEGER NOT NULL,
+  round INTEGER NOT NULL,
+  -- NULL until known, bye1 and bye2 mark slots that will stay empty
+  media1_id INTEGER,
+  media2_id INTEGER,
+  bye1 INTEGER NOT NULL DEFAULT false,
+  bye2 INTEGER NOT NULL DEFAULT false,
+  decided INTEGER NOT NULL DEFAULT false,
+  winner_id INTEGER,
+  -- number of the match the winner and loser move on to, -1 for none
+  winner_to INTEGER NOT NULL,
+  winner_slot INTEGER NOT NULL,
+  loser_to INTEGER NOT NULL,
+  loser_slot INTEGER NOT NULL,
+  comparison_id INTEGER,
+  UNIQUE(bracket_id, number),
+  FOREIGN KEY(bracket_id) REFERENCES brackets(id) ON DELETE CASCADE,
+  FOREIGN KEY(comparison_id) REFERENCES comparisons(id) ON DELETE SET NULL
+);
+
 -- Maybe a good idea, maybe not
 -- CREATE TRIGGER IF NOT EXISTS update_matches AFTER INSERT ON comparisons
 -- BEGIN
@@ -47,6 +211,74 @@ CREATE INDEX IF NOT EXISTS comparisons_loser_id_idx ON comparisons(loser_id);
 --
 `
 
+// columnMigrations adds columns introduced after a table was first
+// created, since CREATE TABLE IF NOT EXISTS leaves existing tables
+// untouched.
+var columnMigrations = []struct {
+	table string
+	column string
+	definition string
+}{
+	// Glicko-2 rating deviation and volatility
+	{ "media", "deviation", "REAL NOT NULL DEFAULT 350" },
+	{ "media", "volatility", "REAL NOT NULL DEFAULT 0.06" },
+	// TrueSkill mean and uncertainty
+	{ "media", "mu", "REAL NOT NULL DEFAULT 1500" },
+	{ "media", "sigma", "REAL NOT NULL DEFAULT 500" },
+	// Win or draw, see Outcome
+	{ "comparisons", "outcome", "INTEGER NOT NULL DEFAULT 0" },
+	// Points the loser moved, older rows fall back to -points
+	{ "comparisons", "loser_points", "INTEGER" },
+	// K-factor used for each side, NULL for raters without one
+	{ "comparisons", "winner_k", "REAL" },
+	{ "comparisons", "loser_k", "REAL" },
+	// Collection the comparison was made in, NULL for the global ranking
+	{ "comparisons", "collection_id", "INTEGER REFERENCES collections(id) ON DELETE CASCADE" },
+	// Comparisons recorded by one vote share the id of the first so
+	// they're undone together, NULL for a single comparison
+	{ "comparisons", "batch_id", "INTEGER" },
+}
+
+func migrateColumns(db *sql.DB) error {
+	for _, migration := range(columnMigrations) {
+		exists, err := columnExists(db, migration.table, migration.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", migration.table, migration.column, migration.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("migrate columns add %s.%s: %w", migration.table, migration.column, err)
+		}
+	}
+	return nil
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return false, fmt.Errorf("column exists query table info: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, fmt.Errorf("column exists scan row: %w", err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	if rows.Err() != nil {
+		return false, fmt.Errorf("column exists rows: %w", rows.Err())
+	}
+
+	return false, nil
+}
+
 func NewServer(dbPath string) (*Server, error) {
 	var dbSpec string
 	if dbPath == ":memory:" {
@@ -62,11 +294,35 @@ func NewServer(dbPath string) (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("new server migration: %w", err)
 	}
-	return &Server{ db: db }, nil
+	if err := migrateColumns(db); err != nil {
+		return nil, fmt.Errorf("new server migration: %w", err)
+	}
+	s := &Server{
+		db: db,
+		rater: NewEloRater(),
+		selector: RandomSelector{},
+		recent: newRecentMemory(0, 0, false),
+		thumbnails: thumbnailDir,
+	}
+	ids, err := s.mediaIds()
+	if err != nil {
+		return nil, fmt.Errorf("new server load media index: %w", err)
+	}
+	s.index = newMediaIndex(ids)
+	return s, nil
 }
 
 type Server struct {
 	db *sql.DB
+	rater Rater
+	selector Selector
+	recent *recentMemory
+	// index holds every media id for fast random selection
+	index *mediaIndex
+	// placeNew queues uncompared media found by the scan for placement
+	placeNew bool
+	// thumbnails is the directory thumbnails are cached in
+	thumbnails string
 }
 
 func (s *Server) Close() error {
@@ -74,38 +330,87 @@ func (s *Server) Close() error {
 }
 
 const insertMediaQuery = `
-INSERT INTO media(path, sha1sum, score, matches) VALUES (?, ?, 1500, 0)
+INSERT INTO media(path, sha1sum, score, matches) VALUES (?, ?, ?, 0)
   ON CONFLICT(sha1sum) DO UPDATE SET path = ?, deleted = false
+  RETURNING id, matches
 `
 
 func (s *Server) InsertMedia(path string, sha1sum string) (int64, error) {
-	result, err := s.db.Exec(insertMediaQuery, path, sha1sum, path)
-	if err != nil {
+	row := s.db.QueryRow(insertMediaQuery, path, sha1sum, initialScore, path)
+	var rowId int64
+	var matches int
+	if err := row.Scan(&rowId, &matches); err != nil {
 		return 0, fmt.Errorf("failed to insert media into db: %w", err)
 	}
-	rowId, err := result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get ID of inserted media: %w", err)
+	s.index.Add(rowId)
+	if s.placeNew && matches == 0 {
+		if err := s.queuePlacement(rowId); err != nil {
+			return 0, fmt.Errorf("InsertMedia: %w", err)
+		}
 	}
 	return rowId, nil
 }
 
+// RemoveDeletedMedia deletes the media scanMedia didn't find and
+// returns how many were removed.
+func (s *Server) RemoveDeletedMedia() (int, error) {
+	rows, err := s.db.Query("DELETE FROM media WHERE deleted = true RETURNING id")
+	if err != nil {
+		return 0, fmt.Errorf("RemoveDeletedMedia delete failed: %w", err)
+	}
+	defer rows.Close()
+
+	removed := 0
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return removed, fmt.Errorf("RemoveDeletedMedia scan row: %w", err)
+		}
+		s.index.Remove(id)
+		removed++
+	}
+	if rows.Err() != nil {
+		return removed, fmt.Errorf("RemoveDeletedMedia rows: %w", rows.Err())
+	}
+
+	return removed, nil
+}
+
+// mediaColumns are the media table columns read by scanMediaInfo
+const mediaColumns = "id, path, sha1sum, score, matches, deviation, volatility, mu, sigma"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMediaInfo(row rowScanner) (MediaInfo, error) {
+	var info MediaInfo
+	err := row.Scan(&info.Id, &info.Path, &info.Sha1, &info.Score, &info.Matches, &info.Deviation, &info.Volatility, &info.Mu, &info.Sigma)
+	return info, err
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 func (s *Server) GetMediaInfo(mediaId int64) (MediaInfo, error) {
-	row := s.db.QueryRow("SELECT id, path, sha1sum, score, matches FROM media WHERE id = ?", mediaId)
+	return queryMediaInfo(s.db, mediaId)
+}
+
+func queryMediaInfo(db querier, mediaId int64) (MediaInfo, error) {
+	row := db.QueryRow("SELECT " + mediaColumns + " FROM media WHERE id = ?", mediaId)
 	if row.Err() != nil {
 		return MediaInfo{}, fmt.Errorf("failed to get media info from db: %w", row.Err())
 	}
-	var id int64
-	var mediaPath string
-	var sha1 string
-	var score int
-	var matches int
 
-	if err := row.Scan(&id, &mediaPath, &sha1, &score, &matches); err != nil {
+	info, err := scanMediaInfo(row)
+	if err != nil {
 		return MediaInfo{}, fmt.Errorf("get media failed to scan row: %w", err)
 	}
 
-	return MediaInfo{ Id: id, Path: mediaPath, Sha1: sha1, Score: score, Matches: matches }, nil
+	return info, nil
 }
 
 func (s *Server) MediaCount() (int64, error) {
@@ -134,95 +439,429 @@ func (s *Server) ComparisonCount() (int64, error) {
 	return rowCount, nil
 }
 
-func (s *Server) UpdateScores(winnerId int64, loserId int64) error {
-	winnerRow := s.db.QueryRow("SELECT score FROM media WHERE id = ?", winnerId)
-	if winnerRow.Err() != nil {
-		return fmt.Errorf("update scores fetch winner row: %w", winnerRow.Err())
+func (s *Server) UpdateScores(winnerId int64, loserId int64, outcome Outcome) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("update scores create new transaction: %w", err)
+	}
+
+	if _, err := s.updateScoresTx(tx, globalLeaderboard, winnerId, loserId, outcome); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	var winnerScore int
-	if err := winnerRow.Scan(&winnerScore); err != nil {
-		return fmt.Errorf("update scores scan winner row: %w", err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("update scores commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateCollectionScores records a comparison made within a collection,
+// only the ratings of both media in that collection change.
+func (s *Server) UpdateCollectionScores(collectionId int64, winnerId int64, loserId int64, outcome Outcome) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpdateCollectionScores create new transaction: %w", err)
 	}
 
-	loserRow := s.db.QueryRow("SELECT score FROM media WHERE id = ?", loserId)
-	if loserRow.Err() != nil {
-		return fmt.Errorf("update scores fetch loser row: %w", loserRow.Err())
+	if _, err := s.updateScoresTx(tx, leaderboard(collectionId), winnerId, loserId, outcome); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("UpdateCollectionScores: %w", err)
 	}
 
-	var loserScore int
-	if err := loserRow.Scan(&loserScore); err != nil {
-		return fmt.Errorf("update scores scan loser row: %w", err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateCollectionScores commit transaction: %w", err)
 	}
 
-	winnerNewScore, loserNewScore := calculateNewEloScores(winnerScore, loserScore)
+	return nil
+}
 
-	pointsDifference := winnerNewScore - winnerScore
+// RecordRanking records the comparisons implied by ranking, best
+// first. If complete every media beat every media ranked below it,
+// otherwise only the first media was picked and beat all the others.
+func (s *Server) RecordRanking(ranking []int64, complete bool) error {
+	if len(ranking) < 2 {
+		return fmt.Errorf("RecordRanking needs at least 2 media, found %d", len(ranking))
+	}
+	seen := make(map[int64]bool, len(ranking))
+	for _, id := range(ranking) {
+		if seen[id] {
+			return fmt.Errorf("RecordRanking media %d is ranked twice", id)
+		}
+		seen[id] = true
+	}
 
 	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("update scores create new transaction: %w", err)
+		return fmt.Errorf("RecordRanking create new transaction: %w", err)
+	}
+
+	var batchId int64
+	for i, winnerId := range(ranking) {
+		for _, loserId := range(ranking[i + 1:]) {
+			comparisonId, err := s.updateScoresTx(tx, globalLeaderboard, winnerId, loserId, OutcomeWin)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("RecordRanking: %w", err)
+			}
+			if batchId == 0 {
+				batchId = comparisonId
+			}
+			if _, err := tx.Exec("UPDATE comparisons SET batch_id = ? WHERE id = ?", batchId, comparisonId); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("RecordRanking set batch: %w", err)
+			}
+		}
+		if !complete {
+			break
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("RecordRanking commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// updateScoresTx records a comparison and rates both media on board
+// inside tx, returning the id of the new comparison.
+func (s *Server) updateScoresTx(tx *sql.Tx, board leaderboard, winnerId int64, loserId int64, outcome Outcome) (int64, error) {
+	winner, err := queryRating(tx, board, winnerId)
+	if err != nil {
+		return 0, fmt.Errorf("update scores fetch winner: %w", err)
 	}
 
-	_, err = tx.Exec("INSERT INTO comparisons(winner_id, loser_id, points) VALUES (?, ?, ?)", winnerId, loserId, pointsDifference)
+	loser, err := queryRating(tx, board, loserId)
 	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("update scores inserting new comparison: %w ", err)
+		return 0, fmt.Errorf("update scores fetch loser: %w", err)
+	}
+
+	winnerNew, loserNew := s.rater.Rate(winner, loser, outcome)
+	winnerK, loserK := usedK(s.rater, winner, loser)
+
+	pointsDifference := winnerNew.Score - winner.Score
+	loserPointsDifference := loserNew.Score - loser.Score
+
+	result, err := tx.Exec(
+		"INSERT INTO comparisons(winner_id, loser_id, points, loser_points, outcome, winner_k, loser_k, collection_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		winnerId, loserId, pointsDifference, loserPointsDifference, outcome, winnerK, loserK, board.collectionId(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("update scores inserting new comparison: %w ", err)
+	}
+	comparisonId, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("update scores get comparison id: %w", err)
+	}
+
+	if err := saveRating(tx, board, winnerNew); err != nil {
+		return 0, fmt.Errorf("update scores update winner score: %w", err)
+	}
+
+	if err := saveRating(tx, board, loserNew); err != nil {
+		return 0, fmt.Errorf("update scores update loser score: %w", err)
+	}
+
+	return comparisonId, nil
+}
+
+// usedK returns the K-factors rater uses for winner and loser, or NULL
+// if it doesn't have one.
+func usedK(rater Rater, winner, loser MediaInfo) (sql.NullFloat64, sql.NullFloat64) {
+	k, ok := rater.(kRater)
+	if !ok {
+		return sql.NullFloat64{}, sql.NullFloat64{}
+	}
+	return sql.NullFloat64{ Float64: k.KFor(winner), Valid: true }, sql.NullFloat64{ Float64: k.KFor(loser), Valid: true }
+}
+
+// saveRating writes the rating fields of media on board and counts the
+// match
+func saveRating(tx *sql.Tx, board leaderboard, media MediaInfo) error {
+	_, err := tx.Exec(
+		board.updateRating("score = ?, deviation = ?, volatility = ?, mu = ?, sigma = ?, matches = matches + 1"),
+		media.Score, media.Deviation, media.Volatility, media.Mu, media.Sigma, media.Id,
+	)
+	return err
+}
+
+var NoComparisonsError = errors.New("no comparisons to undo")
+
+// MatchComparisonError is returned when undoing or editing a comparison
+// that decided a tournament or bracket match, since the match and
+// everything paired or advanced after it would no longer agree with it
+var MatchComparisonError = errors.New("comparison decided a tournament or bracket match")
+
+// checkNotMatch returns MatchComparisonError if comparison id decided
+// a tournament or bracket match
+func checkNotMatch(db querier, id int64) error {
+	var inMatch bool
+	err := db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM tournament_matches WHERE comparison_id = ?)
+		   OR EXISTS(SELECT 1 FROM bracket_matches WHERE comparison_id = ?)`,
+		id, id,
+	).Scan(&inMatch)
+	if err != nil {
+		return fmt.Errorf("check comparison %d match: %w", id, err)
+	}
+	if inMatch {
+		return MatchComparisonError
+	}
+	return nil
+}
+
+// UndoLastComparison deletes the most recent comparison, or every
+// comparison of the most recent vote if it recorded several, and rolls
+// back the scores and matches of the media in one transaction. Scores are
+// restored from the points the comparison moved them. Raters that track
+// uncertainty can't be reversed from points alone, so for those the
+// remaining history is rebuilt instead.
+func (s *Server) UndoLastComparison() error {
+	return s.undoLastComparison(globalLeaderboard)
+}
+
+// UndoLastCollectionComparison undoes the most recent comparison made
+// within a collection.
+func (s *Server) UndoLastCollectionComparison(collectionId int64) error {
+	return s.undoLastComparison(leaderboard(collectionId))
+}
+
+func (s *Server) undoLastComparison(board leaderboard) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UndoLastComparison create new transaction: %w", err)
 	}
 
-	if _, err := tx.Exec("UPDATE media SET score = ?, matches = matches + 1 WHERE id = ?", winnerNewScore, winnerId); err != nil {
+	var lastId int64
+	var batchId sql.NullInt64
+	row := tx.QueryRow("SELECT id, batch_id FROM comparisons WHERE " + board.comparisons() + " ORDER BY id DESC LIMIT 1")
+	if err := row.Scan(&lastId, &batchId); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("update scores update winner score: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return NoComparisonsError
+		}
+		return fmt.Errorf("UndoLastComparison scan last comparison: %w", err)
 	}
 
-	if _, err := tx.Exec("UPDATE media SET score = ?, matches = matches + 1 WHERE id = ?", loserNewScore, loserId); err != nil {
+	type undone struct {
+		id, winnerId, loserId int64
+		points, loserPoints int
+	}
+	var comparisons []undone
+	rows, err := tx.Query(
+		"SELECT id, winner_id, loser_id, points, COALESCE(loser_points, -points) FROM comparisons WHERE id = ? OR batch_id = ? ORDER BY id DESC",
+		lastId, batchId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("UndoLastComparison query last vote: %w", err)
+	}
+	for rows.Next() {
+		var c undone
+		if err := rows.Scan(&c.id, &c.winnerId, &c.loserId, &c.points, &c.loserPoints); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return fmt.Errorf("UndoLastComparison scan last vote: %w", err)
+		}
+		comparisons = append(comparisons, c)
+	}
+	rows.Close()
+	if rows.Err() != nil {
 		tx.Rollback()
-		return fmt.Errorf("update scores update loser score: %w", err)
+		return fmt.Errorf("UndoLastComparison last vote rows: %w", rows.Err())
+	}
+
+	_, tracksUncertainty := s.rater.(uncertaintyRater)
+	restore := board.updateRating("score = score - ?, matches = matches - 1")
+	for _, c := range(comparisons) {
+		if err := checkNotMatch(tx, c.id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := restorePlacement(tx, c.id); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("UndoLastComparison: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM comparisons WHERE id = ?", c.id); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("UndoLastComparison delete comparison %d: %w", c.id, err)
+		}
+		if tracksUncertainty {
+			continue
+		}
+		if _, err := tx.Exec(restore, c.points, c.winnerId); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("UndoLastComparison restore winner: %w", err)
+		}
+		if _, err := tx.Exec(restore, c.loserPoints, c.loserId); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("UndoLastComparison restore loser: %w", err)
+		}
+	}
+
+	if tracksUncertainty {
+		if err := s.rebuildTx(tx, board); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("UndoLastComparison: %w", err)
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("update scores commit transaction: %w", err)
+		return fmt.Errorf("UndoLastComparison commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-var NotEnoughMediaError = errors.New("not enough media in database")
+// DeleteComparison removes a comparison from the history and rebuilds
+// every score on its leaderboard from the comparisons that remain,
+// from the start and with the fit the scores were last rebuilt with.
+func (s *Server) DeleteComparison(id int64) error {
+	return s.editComparison("DELETE FROM comparisons WHERE id = ?", id)
+}
+
+// FlipComparison swaps the winner and loser of a comparison and
+// rebuilds every score from the corrected history.
+func (s *Server) FlipComparison(id int64) error {
+	return s.editComparison("UPDATE comparisons SET winner_id = loser_id, loser_id = winner_id WHERE id = ?", id)
+}
 
-func (s *Server) SelectMediaForComparison() (MediaInfo, MediaInfo, error) {
-	// FIXME This can be slow on large tables. It could be preferable
-	// to get the number of records, select two random numbers within
-	// that range and then query for them manually
-	// http://www.titov.net/2005/09/21/do-not-use-order-by-rand-or-how-to-get-random-rows-from-table/
-	rows, err := s.db.Query("SELECT id FROM media ORDER BY RANDOM() LIMIT 2")
+func (s *Server) editComparison(query string, id int64) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return MediaInfo{}, MediaInfo{}, fmt.Errorf("select media for comparison query failed: %w", err)
+		return fmt.Errorf("edit comparison create new transaction: %w", err)
 	}
-	defer rows.Close()
 
-	var id1, id2 int64
-	if !rows.Next() {
-		return MediaInfo{}, MediaInfo{}, NotEnoughMediaError
+	var collectionId sql.NullInt64
+	if err := tx.QueryRow("SELECT collection_id FROM comparisons WHERE id = ?", id).Scan(&collectionId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("edit comparison %d: %w", id, err)
+	}
+	if err := checkNotMatch(tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("edit comparison %d: %w", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("edit comparison %d rows affected: %w", id, err)
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("edit comparison %d: %w", id, sql.ErrNoRows)
+	}
+
+	if err := s.rebuildTx(tx, leaderboard(collectionId.Int64)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("edit comparison %d: %w", id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("edit comparison commit transaction: %w", err)
 	}
-	if err := rows.Scan(&id1); err != nil {
-		return MediaInfo{}, MediaInfo{}, fmt.Errorf("select comparison failed to scan: %w", err)
+
+	return nil
+}
+
+// RecordSkip remembers that the user couldn't decide between two media.
+func (s *Server) RecordSkip(media1Id, media2Id int64) error {
+	if _, err := s.db.Exec("INSERT INTO skips(media1_id, media2_id) VALUES (?, ?)", media1Id, media2Id); err != nil {
+		return fmt.Errorf("RecordSkip insert skip: %w", err)
+	}
+	return nil
+}
+
+// recentSkipWindow is how many of the latest skips RecentlySkipped
+// looks through.
+const recentSkipWindow = 50
+
+// RecentlySkipped reports whether the pair, in either order, is among
+// the most recent skips.
+func (s *Server) RecentlySkipped(media1Id, media2Id int64) (bool, error) {
+	row := s.db.QueryRow(`
+SELECT COUNT(*) FROM (SELECT media1_id, media2_id FROM skips ORDER BY id DESC LIMIT ?)
+WHERE (media1_id = ? AND media2_id = ?) OR (media1_id = ? AND media2_id = ?)`,
+		recentSkipWindow, media1Id, media2Id, media2Id, media1Id,
+	)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, fmt.Errorf("RecentlySkipped scan row: %w", err)
 	}
-	if !rows.Next() {
-		return MediaInfo{}, MediaInfo{}, NotEnoughMediaError
+	return count > 0, nil
+}
+
+// SkipCounts returns how many times each media has been skipped,
+// media that were never skipped are left out.
+func (s *Server) SkipCounts() (map[int64]int, error) {
+	rows, err := s.db.Query(`
+SELECT media_id, COUNT(*) FROM (
+  SELECT media1_id media_id FROM skips
+  UNION ALL
+  SELECT media2_id media_id FROM skips
+) GROUP BY media_id`)
+	if err != nil {
+		return nil, fmt.Errorf("SkipCounts query failed: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, fmt.Errorf("SkipCounts scan row: %w", err)
+		}
+		counts[id] = count
 	}
-	if err := rows.Scan(&id2); err != nil {
-		return MediaInfo{}, MediaInfo{}, fmt.Errorf("select comparison failed to scan: %w", err)
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("SkipCounts rows: %w", rows.Err())
 	}
 
-	if err := rows.Close(); err != nil {
-		return MediaInfo{}, MediaInfo{}, fmt.Errorf("select comparison failed to close rows: %w", err)
+	return counts, nil
+}
+
+var NotEnoughMediaError = errors.New("not enough media in database")
+
+// maxSelectionAttempts is how many pairs SelectMediaForComparison draws
+// looking for one that wasn't skipped or shown recently before settling
+// for the last one drawn.
+const maxSelectionAttempts = 10
+
+func (s *Server) SelectMediaForComparison(opts SelectOptions) (MediaInfo, MediaInfo, error) {
+	var id1, id2 int64
+	for attempt := 0; attempt < maxSelectionAttempts; attempt++ {
+		var err error
+		id1, id2, err = opts.selector(s).SelectPair(s, opts.Scope)
+		if err != nil {
+			return MediaInfo{}, MediaInfo{}, err
+		}
+		if s.recent.Recent(opts.Session, id1, id2) {
+			continue
+		}
+		skipped, err := s.RecentlySkipped(id1, id2)
+		if err != nil {
+			return MediaInfo{}, MediaInfo{}, fmt.Errorf("select comparison: %w", err)
+		}
+		if !skipped {
+			break
+		}
 	}
+	s.recent.Remember(opts.Session, id1, id2)
 
-	media1, err := s.GetMediaInfo(id1)
+	board := opts.Scope.board()
+	media1, err := queryRating(s.db, board, id1)
 	if err != nil {
 		return MediaInfo{}, MediaInfo{}, fmt.Errorf("select comparisons failed to get media1 info: %w", err)
 	}
-	media2, err := s.GetMediaInfo(id2)
+	media2, err := queryRating(s.db, board, id2)
 	if err != nil {
 		return MediaInfo{}, MediaInfo{}, fmt.Errorf("select comparisons failed to get media2 info: %w", err)
 	}
@@ -230,38 +869,215 @@ func (s *Server) SelectMediaForComparison() (MediaInfo, MediaInfo, error) {
 	return media1, media2, nil
 }
 
-func (s *Server) SortedList(descending bool) ([]MediaInfo, error) {
+// SelectMediaGroup picks n random media for a best of n face-off, or
+// every media if there are fewer.
+func (s *Server) SelectMediaGroup(n int) ([]MediaInfo, error) {
+	ids := s.index.Sample(n)
+	if len(ids) < 2 {
+		return nil, NotEnoughMediaError
+	}
+	group, err := s.mediaByIds(ids)
+	if err != nil {
+		return nil, fmt.Errorf("SelectMediaGroup: %w", err)
+	}
+	rand.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+	return group, nil
+}
+
+func (s *Server) randomPair(scope Scope) (int64, int64, error) {
+	if !scope.IsZero() {
+		pair, err := s.randomMedia(scope, 2)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(pair) < 2 {
+			return 0, 0, NotEnoughMediaError
+		}
+		return pair[0].Id, pair[1].Id, nil
+	}
+	ids := s.index.Sample(2)
+	if len(ids) < 2 {
+		return 0, 0, NotEnoughMediaError
+	}
+	return ids[0], ids[1], nil
+}
+
+// randomMedia returns up to n media in scope picked at random
+func (s *Server) randomMedia(scope Scope, n int) ([]MediaInfo, error) {
+	if scope.IsZero() {
+		return s.mediaByIds(s.index.Sample(n))
+	}
+	// A random sample of the library finds media in a broad scope,
+	// only a narrow one is left to a query over the scope
+	sample, err := s.mediaInScope(scope, s.index.Sample(n * scopeSampleFactor))
+	if err != nil {
+		return nil, err
+	}
+	if len(sample) >= 2 {
+		// the rows come back in id order, shuffled so the ones kept
+		// aren't the lowest ids
+		rand.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+		if len(sample) > n {
+			sample = sample[:n]
+		}
+		return sample, nil
+	}
+	return s.randomMediaInScope(scope, n)
+}
+
+// randomMediaUnder returns up to n media in scope with fewer than
+// matches matches, picked at random
+func (s *Server) randomMediaUnder(scope Scope, matches int, n int) ([]MediaInfo, error) {
+	// While plenty of media is under the limit a random sample finds
+	// it, once few are left the matches index makes the query cheap
+	sample, err := s.randomMedia(scope, n * 4)
+	if err != nil {
+		return nil, err
+	}
+	// shuffled so the media kept doesn't depend on the order the rows
+	// came back in
+	rand.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+	under := make([]MediaInfo, 0, n)
+	for _, media := range(sample) {
+		if media.Matches < matches && len(under) < n {
+			under = append(under, media)
+		}
+	}
+	if len(under) >= 2 {
+		return under, nil
+	}
+
+	where, args := scope.where("ratings")
+	args = append([]any{ matches }, args...)
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM (%s) ratings WHERE matches < ? AND %s ORDER BY RANDOM() LIMIT ?", mediaColumns, scope.board().ratingsQuery(), where), append(args, n)...)
+	if err != nil {
+		return nil, fmt.Errorf("randomMediaUnder query failed: %w", err)
+	}
+	return scanMediaList(rows, n)
+}
+
+// topMedia returns the n highest ranked media in scope, best first
+func (s *Server) topMedia(scope Scope, n int) ([]MediaInfo, error) {
+	where, args := scope.where("ratings")
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM (%s) ratings WHERE %s ORDER BY %s DESC LIMIT ?", mediaColumns, scope.board().ratingsQuery(), where, s.rankBy()), append(args, n)...)
+	if err != nil {
+		return nil, fmt.Errorf("topMedia query failed: %w", err)
+	}
+	return scanMediaList(rows, n)
+}
+
+// randomMediaInBand returns up to n media in scope scored between min
+// and max inclusive, picked at random
+func (s *Server) randomMediaInBand(scope Scope, min, max int, n int) ([]MediaInfo, error) {
+	where, args := scope.where("ratings")
+	args = append([]any{ min, max }, args...)
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM (%s) ratings WHERE score BETWEEN ? AND ? AND %s ORDER BY RANDOM() LIMIT ?", mediaColumns, scope.board().ratingsQuery(), where), append(args, n)...)
+	if err != nil {
+		return nil, fmt.Errorf("randomMediaInBand query failed: %w", err)
+	}
+	return scanMediaList(rows, n)
+}
+
+// mediaByIds returns the media with the given ids, in the order of
+// ids. Ids that don't exist are left out.
+func (s *Server) mediaByIds(ids []int64) ([]MediaInfo, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.Repeat(", ?", len(ids))[2:]
+	args := make([]any, len(ids))
+	for i, id := range(ids) {
+		args[i] = id
+	}
+	rows, err := s.db.Query("SELECT " + mediaColumns + " FROM media WHERE id IN (" + placeholders + ")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("mediaByIds query failed: %w", err)
+	}
+	found, err := scanMediaList(rows, len(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	// the rows come back in id order, put back in the order asked for
+	byId := make(map[int64]MediaInfo, len(found))
+	for _, media := range(found) {
+		byId[media.Id] = media
+	}
+	media := make([]MediaInfo, 0, len(found))
+	for _, id := range(ids) {
+		if info, ok := byId[id]; ok {
+			media = append(media, info)
+		}
+	}
+	return media, nil
+}
+
+// CoverageProgress returns how many media have at least minMatches
+// matches, out of the total.
+func (s *Server) CoverageProgress(minMatches int) (int64, int64, error) {
+	row := s.db.QueryRow("SELECT COUNT(*) FILTER (WHERE matches >= ?), COUNT(*) FROM media", minMatches)
+	var covered, total int64
+	if err := row.Scan(&covered, &total); err != nil {
+		return 0, 0, fmt.Errorf("CoverageProgress scan row: %w", err)
+	}
+	return covered, total, nil
+}
+
+func scanMediaList(rows *sql.Rows, capacity int) ([]MediaInfo, error) {
+	defer rows.Close()
+
+	media := make([]MediaInfo, 0, capacity)
+	for rows.Next() {
+		info, err := scanMediaInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan media list row: %w", err)
+		}
+		media = append(media, info)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("scan media list rows: %w", rows.Err())
+	}
+
+	return media, nil
+}
+
+// rankBy is the SQL expression media are ranked by
+func (s *Server) rankBy() string {
+	if ranked, ok := s.rater.(rankedRater); ok {
+		return ranked.RankExpression()
+	}
+	return "score"
+}
+
+// SortedList returns the media in scope ordered by rank
+func (s *Server) SortedList(descending bool, scope Scope) ([]MediaInfo, error) {
 	var order string
 	if descending {
 		order = "DESC"
 	} else {
 		order = "ASC"
 	}
-	query := fmt.Sprintf("SELECT id, path, sha1sum, score, matches FROM media ORDER BY score %s", order)
+	where, args := scope.where("ratings")
+	query := fmt.Sprintf("SELECT %s FROM (%s) ratings WHERE %s ORDER BY %s %s", mediaColumns, scope.board().ratingsQuery(), where, s.rankBy(), order)
 	count, err := s.MediaCount()
 	if err != nil {
 		return nil, fmt.Errorf("SortedList failed to get count: %w", err)
 	}
 	list := make([]MediaInfo, 0, count)
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("SortedList query failed: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id int64
-		var mediaPath string
-		var sha1 string
-		var score int
-		var matches int
-
-		if err := rows.Scan(&id, &mediaPath, &sha1, &score, &matches); err != nil {
+		info, err := scanMediaInfo(rows)
+		if err != nil {
 			return nil, fmt.Errorf("SortedList failed to scan row: %w", err)
 		}
 
-		list = append(list, MediaInfo{Id: id, Path: mediaPath, Sha1: sha1, Score: score, Matches: matches })
+		list = append(list, info)
 	}
 	if rows.Err() != nil {
 		return nil, fmt.Errorf("ScanList error while iterating: %w", rows.Err())
@@ -270,9 +1086,314 @@ func (s *Server) SortedList(descending bool) ([]MediaInfo, error) {
 	return list, nil
 }
 
+// RecomputeScores replaces every media score with its Bradley-Terry
+// score fit over every comparison outside of collections.
+func (s *Server) RecomputeScores() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("RecomputeScores create new transaction: %w", err)
+	}
+	if err := recomputeTx(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecomputeScores: %w", err)
+	}
+	start, _, err := scoreBasis(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecomputeScores: %w", err)
+	}
+	if err := setScoreBasis(tx, start, true); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecomputeScores: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("RecomputeScores commit transaction: %w", err)
+	}
+	return nil
+}
+
+func recomputeTx(tx *sql.Tx) error {
+	ids, err := queryMediaIds(tx)
+	if err != nil {
+		return err
+	}
+	results, err := pairResults(tx)
+	if err != nil {
+		return err
+	}
+
+	scores := fitBradleyTerry(ids, results)
+
+	// mu is written too so TrueSkill, which ranks by mu and mirrors it
+	// in score, keeps the fit. Sigma still measures how often media was
+	// compared, which the fit doesn't change.
+	stmt, err := tx.Prepare("UPDATE media SET score = ?, mu = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("recompute prepare update: %w", err)
+	}
+	defer stmt.Close()
+
+	for id, score := range(scores) {
+		if _, err := stmt.Exec(score, score, id); err != nil {
+			return fmt.Errorf("recompute update media %d: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// scoreBasis returns the starting rating the global scores were last
+// replayed from and whether Bradley-Terry was fit over them since
+func scoreBasis(tx *sql.Tx) (int, bool, error) {
+	var start int
+	var bradleyTerry bool
+	err := tx.QueryRow("SELECT start, bradley_terry FROM score_basis WHERE id = 1").Scan(&start, &bradleyTerry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return initialScore, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("score basis scan row: %w", err)
+	}
+	return start, bradleyTerry, nil
+}
+
+func setScoreBasis(tx *sql.Tx, start int, bradleyTerry bool) error {
+	_, err := tx.Exec(
+		"INSERT OR REPLACE INTO score_basis(id, start, bradley_terry) VALUES (1, ?, ?)",
+		start, bradleyTerry,
+	)
+	if err != nil {
+		return fmt.Errorf("set score basis: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) mediaIds() ([]int64, error) {
+	return queryMediaIds(s.db)
+}
+
+func queryMediaIds(db querier) ([]int64, error) {
+	rows, err := db.Query("SELECT id FROM media")
+	if err != nil {
+		return nil, fmt.Errorf("mediaIds query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("mediaIds scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("mediaIds rows: %w", rows.Err())
+	}
+
+	return ids, nil
+}
+
+// sniffedPaths returns the paths of media without an accepted
+// extension, which were picked up by sniffing their content
+func (s *Server) sniffedPaths() (map[string]bool, error) {
+	rows, err := s.db.Query("SELECT path FROM media")
+	if err != nil {
+		return nil, fmt.Errorf("sniffedPaths query failed: %w", err)
+	}
+	defer rows.Close()
+
+	paths := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("sniffedPaths scan row: %w", err)
+		}
+		if !isMediaFile(path) {
+			paths[path] = true
+		}
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("sniffedPaths rows: %w", rows.Err())
+	}
+
+	return paths, nil
+}
+
+func pairResults(tx *sql.Tx) ([]pairResult, error) {
+	rows, err := tx.Query("SELECT winner_id, loser_id, outcome FROM comparisons WHERE " + globalLeaderboard.comparisons() + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("pairResults query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []pairResult
+	for rows.Next() {
+		var result pairResult
+		if err := rows.Scan(&result.winnerId, &result.loserId, &result.outcome); err != nil {
+			return nil, fmt.Errorf("pairResults scan row: %w", err)
+		}
+		results = append(results, result)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("pairResults rows: %w", rows.Err())
+	}
+
+	return results, nil
+}
+
+// Replay resets every media to the starting rating start and
+// re-applies each comparison, in the order they were made, through
+// rater. The points of every comparison are rewritten to match.
+func (s *Server) Replay(rater Rater, start int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Replay create new transaction: %w", err)
+	}
+	if err := replayTx(tx, globalLeaderboard, rater, start); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Replay: %w", err)
+	}
+	if err := setScoreBasis(tx, start, false); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Replay: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Replay commit transaction: %w", err)
+	}
+	return nil
+}
+
+// rebuildTx rebuilds the scores on board from its history after it was
+// edited. The global scores are rebuilt the way they were last built,
+// collections are never replayed from another start.
+func (s *Server) rebuildTx(tx *sql.Tx, board leaderboard) error {
+	start, bradleyTerry := initialScore, false
+	if board == globalLeaderboard {
+		var err error
+		if start, bradleyTerry, err = scoreBasis(tx); err != nil {
+			return err
+		}
+	}
+	if err := replayTx(tx, board, s.rater, start); err != nil {
+		return err
+	}
+	if bradleyTerry {
+		return recomputeTx(tx)
+	}
+	return nil
+}
+
+// replayTx replays the comparisons on board
+func replayTx(tx *sql.Tx, board leaderboard, rater Rater, start int) error {
+	media, err := loadMedia(tx, board)
+	if err != nil {
+		return err
+	}
+	for id, info := range(media) {
+		media[id] = resetRating(info, start)
+	}
+
+	rows, err := tx.Query(`
+SELECT c.id, c.winner_id, c.loser_id, c.outcome, p.media_id, p.placed_score
+FROM comparisons c LEFT JOIN placement_steps p ON p.comparison_id = c.id
+WHERE c.` + board.comparisons() + " ORDER BY c.id")
+	if err != nil {
+		return fmt.Errorf("replay query comparisons: %w", err)
+	}
+	type replayComparison struct {
+		id int64
+		winnerId int64
+		loserId int64
+		outcome Outcome
+		// placedId was placed at placedScore by the comparison
+		placedId sql.NullInt64
+		placedScore sql.NullInt64
+	}
+	var comparisons []replayComparison
+	for rows.Next() {
+		var c replayComparison
+		if err := rows.Scan(&c.id, &c.winnerId, &c.loserId, &c.outcome, &c.placedId, &c.placedScore); err != nil {
+			rows.Close()
+			return fmt.Errorf("replay scan comparison: %w", err)
+		}
+		comparisons = append(comparisons, c)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return fmt.Errorf("replay comparison rows: %w", rows.Err())
+	}
+
+	for _, c := range(comparisons) {
+		winner, loser := media[c.winnerId], media[c.loserId]
+		winnerNew, loserNew := rater.Rate(winner, loser, c.outcome)
+		winnerK, loserK := usedK(rater, winner, loser)
+		winnerNew.Matches++
+		loserNew.Matches++
+		if c.placedScore.Valid {
+			placed := int(c.placedScore.Int64)
+			if c.placedId.Int64 == c.winnerId {
+				winnerNew.Score, winnerNew.Mu = placed, float64(placed)
+			} else {
+				loserNew.Score, loserNew.Mu = placed, float64(placed)
+			}
+		}
+		media[c.winnerId], media[c.loserId] = winnerNew, loserNew
+
+		_, err := tx.Exec(
+			"UPDATE comparisons SET points = ?, loser_points = ?, winner_k = ?, loser_k = ? WHERE id = ?",
+			winnerNew.Score - winner.Score, loserNew.Score - loser.Score, winnerK, loserK, c.id,
+		)
+		if err != nil {
+			return fmt.Errorf("replay update comparison %d: %w", c.id, err)
+		}
+	}
+
+	stmt, err := tx.Prepare(board.updateRating("score = ?, deviation = ?, volatility = ?, mu = ?, sigma = ?, matches = ?"))
+	if err != nil {
+		return fmt.Errorf("replay prepare media update: %w", err)
+	}
+	defer stmt.Close()
+	for _, info := range(media) {
+		if _, err := stmt.Exec(info.Score, info.Deviation, info.Volatility, info.Mu, info.Sigma, info.Matches, info.Id); err != nil {
+			return fmt.Errorf("replay update media %d: %w", info.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// loadMedia reads every media on board with its rating there, keyed
+// by id
+func loadMedia(tx *sql.Tx, board leaderboard) (map[int64]MediaInfo, error) {
+	rows, err := tx.Query(board.ratingsQuery())
+	if err != nil {
+		return nil, fmt.Errorf("loadMedia query failed: %w", err)
+	}
+	defer rows.Close()
+
+	media := make(map[int64]MediaInfo)
+	for rows.Next() {
+		info, err := scanMediaInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("loadMedia scan row: %w", err)
+		}
+		media[info.Id] = info
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("loadMedia rows: %w", rows.Err())
+	}
+
+	return media, nil
+}
+
 type Comparison struct {
 	Id int64
 	Points int
+	Outcome Outcome
+	// WinnerK and LoserK are the K-factors used, 0 if unknown
+	WinnerK float64
+	LoserK float64
 	Winner MediaInfo
 	Loser MediaInfo
 }
@@ -281,66 +1402,56 @@ const historyQuery = `
 SELECT
   c.id id,
   c.points points,
+  c.outcome outcome,
+  COALESCE(c.winner_k, 0) winner_k, COALESCE(c.loser_k, 0) loser_k,
   w.id winner_id, w.path winner_path, w.sha1sum winner_sha1sum, w.score winner_score, w.matches winner_matches,
-  l.id loser_id, l.path loser_path, l.sha1sum loser_sha1sum, l.score loser_score, l.matches loser_matches
+  w.deviation winner_deviation, w.volatility winner_volatility, w.mu winner_mu, w.sigma winner_sigma,
+  l.id loser_id, l.path loser_path, l.sha1sum loser_sha1sum, l.score loser_score, l.matches loser_matches,
+  l.deviation loser_deviation, l.volatility loser_volatility, l.mu loser_mu, l.sigma loser_sigma
 FROM comparisons c
-JOIN media w ON c.winner_id = w.id
-JOIN media l ON c.loser_id = l.id
+JOIN (%[1]s) w ON c.winner_id = w.id
+JOIN (%[1]s) l ON c.loser_id = l.id
+WHERE c.%[2]s AND %[3]s AND %[4]s
 ORDER BY id DESC
 `
-func (s *Server) Comparisons() ([]Comparison, error) {
+// IsDraw reports whether neither side of the comparison won. Winner
+// and Loser are then just the left and right media.
+func (c Comparison) IsDraw() bool {
+	return c.Outcome == OutcomeDraw
+}
+
+// Comparisons returns the comparisons between media in scope made on
+// its leaderboard, latest first
+func (s *Server) Comparisons(scope Scope) ([]Comparison, error) {
 	count, err := s.ComparisonCount()
 	if err != nil {
 		return nil, fmt.Errorf("Server.Comparisons failed to get count: %w", err)
 	}
 	list := make([]Comparison, 0, count)
 
-	rows, err := s.db.Query(historyQuery)
+	winnerWhere, winnerArgs := scope.where("w")
+	loserWhere, loserArgs := scope.where("l")
+	board := scope.board()
+	query := fmt.Sprintf(historyQuery, board.ratingsQuery(), board.comparisons(), winnerWhere, loserWhere)
+	rows, err := s.db.Query(query, append(winnerArgs, loserArgs...)...)
 	if err != nil {
 		return nil, fmt.Errorf("Server.Comparisons query failed")
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id int64
-		var points int
-		var winnerId int64
-		var winnerPath string
-		var winnerSha1 string
-		var winnerScore int
-		var winnerMatches int
-		var loserId int64
-		var loserPath string
-		var loserSha1 string
-		var loserScore int
-		var loserMatches int
-
+		var c Comparison
 		if err := rows.Scan(
-			&id, &points,
-			&winnerId, &winnerPath, &winnerSha1, &winnerScore, &winnerMatches,
-			&loserId, &loserPath, &loserSha1, &loserScore, &loserMatches,
+			&c.Id, &c.Points, &c.Outcome, &c.WinnerK, &c.LoserK,
+			&c.Winner.Id, &c.Winner.Path, &c.Winner.Sha1, &c.Winner.Score, &c.Winner.Matches,
+			&c.Winner.Deviation, &c.Winner.Volatility, &c.Winner.Mu, &c.Winner.Sigma,
+			&c.Loser.Id, &c.Loser.Path, &c.Loser.Sha1, &c.Loser.Score, &c.Loser.Matches,
+			&c.Loser.Deviation, &c.Loser.Volatility, &c.Loser.Mu, &c.Loser.Sigma,
 		); err != nil {
 			return nil, fmt.Errorf("Server.Comparisons scan row: %w", err)
 		}
 
-		list = append(list, Comparison{
-			Id: id,
-			Points: points,
-			Winner: MediaInfo{
-				Id: winnerId,
-				Path: winnerPath,
-				Sha1: winnerSha1,
-				Score: winnerScore,
-				Matches: winnerMatches,
-			},
-			Loser: MediaInfo{
-				Id: loserId,
-				Path: loserPath,
-				Sha1: loserSha1,
-				Score: loserScore,
-				Matches: loserMatches,
-			},
-		})
+		list = append(list, c)
 	}
 
 	if rows.Err() != nil {
@@ -349,14 +1460,3 @@ func (s *Server) Comparisons() ([]Comparison, error) {
 
 	return list, nil
 }
-
-func calculateNewEloScores(winnerScore, loserScore int) (winnerNewScore, loserNewScore int) {
-	// Good reference https://www.omnicalculator.com/sports/elo
-	developmentCoefficient := 30.0
-	influence := 400.0
-	winnerExpectation := 1/(1 + math.Pow(10, float64(loserScore - winnerScore) / influence))
-	loserExpectation := 1.0 - winnerExpectation
-	winnerNewScore = winnerScore + int(developmentCoefficient * (1.0 - winnerExpectation))
-	loserNewScore = loserScore + int(developmentCoefficient * (0.0 - loserExpectation))
-	return winnerNewScore, loserNewScore
-}
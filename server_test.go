@@ -1,35 +1,13 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"math"
 	"testing"
 )
 
-func TestCalculateNewEloScores(t *testing.T) {
-	tests := []struct{
-		winnerBefore int
-		loserBefore int
-		winnerAfter int
-		loserAfter int
-	}{
-		{ 1500, 1500, 1515, 1485 },
-		{ 1300, 1500, 1322, 1478 },
-		{ 1250, 1670, 1277, 1643 },
-		{ 1720, 1321, 1722, 1319 },
-	}
-	for _, test := range(tests) {
-		winnerNewScore, loserNewScore := calculateNewEloScores(test.winnerBefore, test.loserBefore)
-		if winnerNewScore != test.winnerAfter {
-			t.Errorf("winnerBefore: %d, loserBefore: %d, expected winnerNewScore to be %d, found %d",
-				test.winnerBefore, test.loserBefore, test.winnerAfter, winnerNewScore)
-		}
-		if loserNewScore != test.loserAfter {
-			t.Errorf("winnerBefore: %d, loserBefore: %d, expected loserNewScore to be %d, found %d",
-				test.winnerBefore, test.loserBefore, test.loserAfter, loserNewScore)
-		}
-	}
-}
-
 func TestServer(t *testing.T) {
 	t.Run("MediaCount returns number of rows in media table", func(t *testing.T) {
 		s, err := NewServer(":memory:")
@@ -224,7 +202,7 @@ func TestServer(t *testing.T) {
 			if err != nil {
 				t.Fatalf("failed to set loser score: %s", err)
 			}
-			if err := s.UpdateScores(winnerId, loserId); err != nil {
+			if err := s.UpdateScores(winnerId, loserId, OutcomeWin); err != nil {
 				t.Fatalf("failed to update scores: %s", err)
 			}
 
@@ -270,6 +248,207 @@ func TestServer(t *testing.T) {
 		}
 	})
 
+	t.Run("UpdateScores stores Glicko-2 deviation and volatility", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		s.rater = NewGlicko2Rater()
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+
+		before := getMediaInfo(s, id1, t)
+		if before.Deviation != 350 {
+			t.Errorf("expected new media deviation to be 350, found %f", before.Deviation)
+		}
+		if before.Volatility != 0.06 {
+			t.Errorf("expected new media volatility to be 0.06, found %f", before.Volatility)
+		}
+
+		updateScores(s, id1, id2, t)
+
+		winner := getMediaInfo(s, id1, t)
+		loser := getMediaInfo(s, id2, t)
+		if winner.Deviation >= 350 || loser.Deviation >= 350 {
+			t.Errorf("expected deviations to shrink, found %f and %f", winner.Deviation, loser.Deviation)
+		}
+		if winner.Score <= 1500 || loser.Score >= 1500 {
+			t.Errorf("expected scores to move apart, found %d and %d", winner.Score, loser.Score)
+		}
+	})
+
+	t.Run("UpdateScores records draws", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		if err := s.UpdateScores(id1, id2, OutcomeDraw); err != nil {
+			t.Fatalf("failed to update scores: %s", err)
+		}
+		media1 := getMediaInfo(s, id1, t)
+		media2 := getMediaInfo(s, id2, t)
+		if media1.Score != 1500 || media2.Score != 1500 {
+			t.Errorf("expected draw between equal media to keep scores at 1500, found %d and %d", media1.Score, media2.Score)
+		}
+		if media1.Matches != 1 || media2.Matches != 1 {
+			t.Errorf("expected draw to count as a match, found %d and %d", media1.Matches, media2.Matches)
+		}
+		comparisons, err := s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if !comparisons[0].IsDraw() {
+			t.Errorf("expected comparison to be a draw, found %s", comparisons[0].Outcome)
+		}
+	})
+
+	t.Run("UndoLastComparison restores scores and matches", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		if err := s.UndoLastComparison(); !errors.Is(err, NoComparisonsError) {
+			t.Errorf("expected undo with no comparisons to fail, got: %v", err)
+		}
+		updateScores(s, id1, id2, t)
+		before1 := getMediaInfo(s, id1, t)
+		before2 := getMediaInfo(s, id2, t)
+		updateScores(s, id2, id1, t)
+
+		if err := s.UndoLastComparison(); err != nil {
+			t.Fatalf("failed to undo last comparison: %s", err)
+		}
+		compareMediaInfo("restored media1", before1, getMediaInfo(s, id1, t), t)
+		compareMediaInfo("restored media2", before2, getMediaInfo(s, id2, t), t)
+		count, err := s.ComparisonCount()
+		if err != nil {
+			t.Fatalf("failed to get comparison count: %s", err)
+		}
+		if count != 1 {
+			t.Errorf("expected 1 comparison left, found %d", count)
+		}
+	})
+
+	t.Run("UndoLastComparison restores uncertainty", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		s.rater = NewGlicko2Rater()
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		updateScores(s, id1, id2, t)
+		before := getMediaInfo(s, id1, t)
+		updateScores(s, id1, id2, t)
+
+		if err := s.UndoLastComparison(); err != nil {
+			t.Fatalf("failed to undo last comparison: %s", err)
+		}
+		after := getMediaInfo(s, id1, t)
+		compareMediaInfo("restored media1", before, after, t)
+		if math.Abs(before.Deviation - after.Deviation) > 0.000001 {
+			t.Errorf("expected deviation to be restored to %f, found %f", before.Deviation, after.Deviation)
+		}
+	})
+
+	t.Run("DeleteComparison and FlipComparison recompute scores", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		updateScores(s, id2, id1, t)
+		updateScores(s, id1, id2, t)
+		comparisons, err := s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		latest, first := comparisons[0].Id, comparisons[1].Id
+
+		if err := s.DeleteComparison(first); err != nil {
+			t.Fatalf("failed to delete comparison: %s", err)
+		}
+		media1 := getMediaInfo(s, id1, t)
+		media2 := getMediaInfo(s, id2, t)
+		if media1.Score != 1515 || media2.Score != 1485 {
+			t.Errorf("expected scores 1515 and 1485, found %d and %d", media1.Score, media2.Score)
+		}
+		if media1.Matches != 1 || media2.Matches != 1 {
+			t.Errorf("expected 1 match each, found %d and %d", media1.Matches, media2.Matches)
+		}
+
+		if err := s.FlipComparison(latest); err != nil {
+			t.Fatalf("failed to flip comparison: %s", err)
+		}
+		media1 = getMediaInfo(s, id1, t)
+		media2 = getMediaInfo(s, id2, t)
+		if media1.Score != 1485 || media2.Score != 1515 {
+			t.Errorf("expected scores 1485 and 1515, found %d and %d", media1.Score, media2.Score)
+		}
+
+		if err := s.DeleteComparison(999); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected deleting a missing comparison to fail, got: %v", err)
+		}
+	})
+
+	t.Run("editing the history keeps the replay start and fit", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		id3 := insertMedia(s, "c", "ccc", t)
+		updateScores(s, id1, id2, t)
+		updateScores(s, id2, id3, t)
+		updateScores(s, id1, id3, t)
+		if err := s.Replay(s.rater, 1000); err != nil {
+			t.Fatalf("failed to replay: %s", err)
+		}
+
+		comparisons, err := s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if err := s.DeleteComparison(comparisons[0].Id); err != nil {
+			t.Fatalf("failed to delete comparison: %s", err)
+		}
+		// only a over b and b over c remain, replayed from 1000
+		media1, media2, media3 := getMediaInfo(s, id1, t), getMediaInfo(s, id2, t), getMediaInfo(s, id3, t)
+		if media1.Score + media2.Score + media3.Score != 3000 || media1.Score <= 1000 {
+			t.Errorf("expected scores replayed from 1000, found %d %d %d", media1.Score, media2.Score, media3.Score)
+		}
+
+		if err := s.RecomputeScores(); err != nil {
+			t.Fatalf("failed to recompute scores: %s", err)
+		}
+		updateScores(s, id3, id1, t)
+		comparisons, err = s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if err := s.FlipComparison(comparisons[0].Id); err != nil {
+			t.Fatalf("failed to flip comparison: %s", err)
+		}
+		// the history is consistent again, so Bradley-Terry centers
+		// the middle media on 1500
+		if media2 := getMediaInfo(s, id2, t); media2.Score != 1500 {
+			t.Errorf("expected the Bradley-Terry fit to be redone, found %d", media2.Score)
+		}
+	})
+
+	t.Run("UpdateScores stores the K-factor used for each side", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		s.rater = &EloRater{ K: 40, Influence: 400, Schedule: []KStep{{ Matches: 1, K: 10 }} }
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		id3 := insertMedia(s, "c", "ccc", t)
+		updateScores(s, id1, id2, t)
+		updateScores(s, id1, id3, t)
+
+		comparisons, err := s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if comparisons[1].WinnerK != 40 || comparisons[1].LoserK != 40 {
+			t.Errorf("expected first comparison K to be 40/40, found %f/%f", comparisons[1].WinnerK, comparisons[1].LoserK)
+		}
+		if comparisons[0].WinnerK != 10 || comparisons[0].LoserK != 40 {
+			t.Errorf("expected second comparison K to be 10/40, found %f/%f", comparisons[0].WinnerK, comparisons[0].LoserK)
+		}
+		media1 := getMediaInfo(s, id1, t)
+		if media1.Score != 1524 {
+			t.Errorf("expected winner score to be 1524, found %d", media1.Score)
+		}
+	})
+
 	t.Run("SelectMediaForComparison returns valid media", func(t *testing.T) {
 		s, err := NewServer(":memory:")
 		if err != nil {
@@ -279,7 +458,7 @@ func TestServer(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to insert media: %s", err)
 		}
-		_, _, err = s.SelectMediaForComparison()
+		_, _, err = s.SelectMediaForComparison(SelectOptions{})
 		if !errors.Is(err, NotEnoughMediaError) {
 			t.Errorf("expected call to fail because there aren't enough entries in db, got: %s", err)
 		}
@@ -287,7 +466,7 @@ func TestServer(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to insert media: %s", err)
 		}
-		media1, media2, err := s.SelectMediaForComparison()
+		media1, media2, err := s.SelectMediaForComparison(SelectOptions{})
 		if err != nil {
 			t.Fatalf("failed to select media for comparison: %s", err)
 		}
@@ -302,6 +481,58 @@ func TestServer(t *testing.T) {
 		}
 	})
 
+	t.Run("RecordSkip is counted and avoided by SelectMediaForComparison", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		id3 := insertMedia(s, "c", "ccc", t)
+		if err := s.RecordSkip(id1, id2); err != nil {
+			t.Fatalf("failed to record skip: %s", err)
+		}
+		if err := s.RecordSkip(id2, id3); err != nil {
+			t.Fatalf("failed to record skip: %s", err)
+		}
+
+		counts, err := s.SkipCounts()
+		if err != nil {
+			t.Fatalf("failed to get skip counts: %s", err)
+		}
+		if counts[id1] != 1 || counts[id2] != 2 || counts[id3] != 1 {
+			t.Errorf("expected skip counts 1, 2, 1, found %v", counts)
+		}
+
+		skipped, err := s.RecentlySkipped(id2, id1)
+		if err != nil {
+			t.Fatalf("failed to check recent skips: %s", err)
+		}
+		if !skipped {
+			t.Error("expected pair to be recently skipped in either order")
+		}
+		skipped, err = s.RecentlySkipped(id1, id3)
+		if err != nil {
+			t.Fatalf("failed to check recent skips: %s", err)
+		}
+		if skipped {
+			t.Error("expected pair that was never skipped not to be recently skipped")
+		}
+
+		// Two of the three possible pairs were skipped, uniform
+		// selection would only offer id1 and id3 a third of the time
+		fresh := 0
+		for i := 0; i < 30; i++ {
+			media1, media2, err := s.SelectMediaForComparison(SelectOptions{})
+			if err != nil {
+				t.Fatalf("failed to select media for comparison: %s", err)
+			}
+			if media1.Id != id2 && media2.Id != id2 {
+				fresh++
+			}
+		}
+		if fresh < 15 {
+			t.Errorf("expected the pair that wasn't skipped to be offered most of the time, offered %d/30", fresh)
+		}
+	})
+
 	t.Run("SortedList returns correctly sorted list", func(t *testing.T) {
 		s, err := NewServer(":memory:")
 		if err != nil {
@@ -319,10 +550,10 @@ func TestServer(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to insert media: %s", err)
 		}
-		if err := s.UpdateScores(id3, id1); err != nil {
+		if err := s.UpdateScores(id3, id1, OutcomeWin); err != nil {
 			t.Fatalf("failed to update scores: %s", err)
 		}
-		desc, err := s.SortedList(true)
+		desc, err := s.SortedList(true, Scope{})
 		if err != nil {
 			t.Fatalf("failed to get sorted list: %s", err)
 		}
@@ -332,7 +563,7 @@ func TestServer(t *testing.T) {
 		if desc[0].Id != id3 || desc[1].Id != id2 || desc[2].Id != id1 {
 			t.Error("incorrect order returned")
 		}
-		asc, err := s.SortedList(false)
+		asc, err := s.SortedList(false, Scope{})
 		if err != nil {
 			t.Fatalf("failed to get sorted list: %s", err)
 		}
@@ -344,6 +575,90 @@ func TestServer(t *testing.T) {
 		}
 	})
 
+	t.Run("SortedList ranks TrueSkill media by conservative estimate", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		s.rater = NewTrueSkillRater()
+		lucky := insertMedia(s, "lucky", "aaa", t)
+		unlucky := insertMedia(s, "unlucky", "bbb", t)
+		settled := insertMedia(s, "settled", "ccc", t)
+		if _, err := s.db.Exec("UPDATE media SET mu = 1600, sigma = 50 WHERE id = ?", settled); err != nil {
+			t.Fatalf("failed to set settled rating: %s", err)
+		}
+		updateScores(s, lucky, unlucky, t)
+
+		list, err := s.SortedList(true, Scope{})
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		if list[0].Id != settled || list[1].Id != lucky || list[2].Id != unlucky {
+			t.Errorf("incorrect order returned: %d, %d, %d", list[0].Id, list[1].Id, list[2].Id)
+		}
+	})
+
+	t.Run("RecomputeScores writes Bradley-Terry scores", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		id3 := insertMedia(s, "c", "ccc", t)
+		updateScores(s, id1, id2, t)
+		updateScores(s, id2, id3, t)
+		updateScores(s, id1, id3, t)
+
+		if err := s.RecomputeScores(); err != nil {
+			t.Fatalf("failed to recompute scores: %s", err)
+		}
+		media1 := getMediaInfo(s, id1, t)
+		media2 := getMediaInfo(s, id2, t)
+		media3 := getMediaInfo(s, id3, t)
+		if media2.Score != 1500 {
+			t.Errorf("expected middle media score to be 1500, found %d", media2.Score)
+		}
+		if media1.Score - 1500 != 1500 - media3.Score {
+			t.Errorf("expected symmetric scores, found %d and %d", media1.Score, media3.Score)
+		}
+		if media1.Matches != 2 {
+			t.Errorf("expected matches to be left alone, found %d", media1.Matches)
+		}
+		if media1.Mu != float64(media1.Score) {
+			t.Errorf("expected mu to follow the score, found %f", media1.Mu)
+		}
+	})
+
+	t.Run("Replay rebuilds scores from comparison history", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		id1 := insertMedia(s, "a", "aaa", t)
+		id2 := insertMedia(s, "b", "bbb", t)
+		updateScores(s, id1, id2, t)
+		updateScores(s, id1, id2, t)
+		before1 := getMediaInfo(s, id1, t)
+		before2 := getMediaInfo(s, id2, t)
+
+		if err := s.Replay(s.rater, 1500); err != nil {
+			t.Fatalf("failed to replay: %s", err)
+		}
+		compareMediaInfo("unchanged media1", before1, getMediaInfo(s, id1, t), t)
+		compareMediaInfo("unchanged media2", before2, getMediaInfo(s, id2, t), t)
+
+		if err := s.Replay(&EloRater{ K: 10, Influence: 400 }, 1000); err != nil {
+			t.Fatalf("failed to replay: %s", err)
+		}
+		media1 := getMediaInfo(s, id1, t)
+		media2 := getMediaInfo(s, id2, t)
+		if media1.Score != 1009 || media2.Score != 991 {
+			t.Errorf("expected scores 1009 and 991, found %d and %d", media1.Score, media2.Score)
+		}
+		if media1.Matches != 2 || media2.Matches != 2 {
+			t.Errorf("expected 2 matches each, found %d and %d", media1.Matches, media2.Matches)
+		}
+		comparisons, err := s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if comparisons[0].Points != 4 || comparisons[1].Points != 5 {
+			t.Errorf("expected replayed points to be 4 and 5, found %d and %d", comparisons[0].Points, comparisons[1].Points)
+		}
+	})
+
 	t.Run("ComparisonCount returns the correct number of rows", func(t *testing.T) {
 		s := newServer(":memory:", t)
 		id1 := insertMedia(s, "a", "aaa", t)
@@ -377,7 +692,7 @@ func TestServer(t *testing.T) {
 		updateScores(s, id1, id2, t)
 		updateScores(s, id2, id1, t)
 
-		comparisons, err := s.Comparisons()
+		comparisons, err := s.Comparisons(Scope{})
 		if err != nil {
 			t.Fatalf("failed to get comparisons: %s", err)
 		}
@@ -396,6 +711,39 @@ func TestServer(t *testing.T) {
 	})
 }
 
+func TestMigrateColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open db: %s", err)
+	}
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE media (id INTEGER PRIMARY KEY, path TEXT NOT NULL, sha1sum TEXT UNIQUE NOT NULL, score INTEGER, matches INTEGER, deleted INTEGER DEFAULT false)")
+	if err != nil {
+		t.Fatalf("failed to create old media table: %s", err)
+	}
+	_, err = db.Exec("CREATE TABLE comparisons (id INTEGER PRIMARY KEY, winner_id INTEGER NOT NULL, loser_id INTEGER NOT NULL, points INTEGER)")
+	if err != nil {
+		t.Fatalf("failed to create old comparisons table: %s", err)
+	}
+	if _, err := db.Exec("INSERT INTO media(path, sha1sum, score, matches) VALUES ('a', 'aaa', 1500, 0)"); err != nil {
+		t.Fatalf("failed to insert test data: %s", err)
+	}
+	if err := migrateColumns(db); err != nil {
+		t.Fatalf("failed to migrate columns: %s", err)
+	}
+	// Running twice must not try to add the columns again
+	if err := migrateColumns(db); err != nil {
+		t.Fatalf("failed to migrate columns a second time: %s", err)
+	}
+	var deviation float64
+	if err := db.QueryRow("SELECT deviation FROM media").Scan(&deviation); err != nil {
+		t.Fatalf("failed to select migrated column: %s", err)
+	}
+	if deviation != 350 {
+		t.Errorf("expected migrated deviation to be 350, found %f", deviation)
+	}
+}
+
 func newServer(path string, t *testing.T) *Server {
 	s, err := NewServer(":memory:")
 	if err != nil {
@@ -421,7 +769,7 @@ func getMediaInfo(s *Server, id int64, t *testing.T) MediaInfo {
 }
 
 func updateScores(s *Server, winner, loser int64, t *testing.T) {
-	if err := s.UpdateScores(winner, loser); err != nil {
+	if err := s.UpdateScores(winner, loser, OutcomeWin); err != nil {
 		t.Fatalf("failed to update scores: %s", err)
 	}
 }
@@ -443,3 +791,218 @@ func compareMediaInfo(expectedName string, expected, actual MediaInfo, t *testin
 		t.Errorf("expected %s MediaInfo.Sha1 to be %s, found %s", expectedName, expected.Sha1, actual.Sha1)
 	}
 }
+
+func TestMediaIndex(t *testing.T) {
+	index := newMediaIndex([]int64{ 1, 2, 3 })
+	index.Add(3)
+	index.Add(4)
+	if index.Len() != 4 {
+		t.Errorf("expected 4 ids, found %d", index.Len())
+	}
+	index.Remove(2)
+	index.Remove(99)
+	if index.Len() != 3 {
+		t.Errorf("expected 3 ids, found %d", index.Len())
+	}
+	for i := 0; i < 20; i++ {
+		sample := index.Sample(2)
+		if len(sample) != 2 || sample[0] == sample[1] {
+			t.Fatalf("expected 2 different ids, found %v", sample)
+		}
+		for _, id := range(sample) {
+			if id == 2 {
+				t.Errorf("sampled removed id 2")
+			}
+		}
+	}
+	if sample := index.Sample(10); len(sample) != 3 {
+		t.Errorf("expected oversized sample to return every id, found %v", sample)
+	}
+}
+
+func TestRemoveDeletedMedia(t *testing.T) {
+	s := newServer(":memory:", t)
+	id1 := insertMedia(s, "a", "aaa", t)
+	insertMedia(s, "b", "bbb", t)
+	if _, err := s.db.Exec("UPDATE media SET deleted = true"); err != nil {
+		t.Fatalf("failed to mark media deleted: %s", err)
+	}
+	// Found again by the scan
+	if id := insertMedia(s, "a", "aaa", t); id != id1 {
+		t.Errorf("expected existing media to keep id %d, found %d", id1, id)
+	}
+
+	removed, err := s.RemoveDeletedMedia()
+	if err != nil {
+		t.Fatalf("failed to remove deleted media: %s", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 media removed, found %d", removed)
+	}
+	if s.index.Len() != 1 {
+		t.Errorf("expected index to hold 1 id, found %d", s.index.Len())
+	}
+	if _, _, err := s.SelectMediaForComparison(SelectOptions{}); !errors.Is(err, NotEnoughMediaError) {
+		t.Errorf("expected selection to fail with a single media left, got: %v", err)
+	}
+}
+
+func TestRecordRanking(t *testing.T) {
+	t.Run("winner beats every other media", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		ids := []int64{
+			insertMedia(s, "a", "a", t),
+			insertMedia(s, "b", "b", t),
+			insertMedia(s, "c", "c", t),
+			insertMedia(s, "d", "d", t),
+		}
+		if err := s.RecordRanking(ids, false); err != nil {
+			t.Fatalf("failed to record ranking: %s", err)
+		}
+		comparisons, err := s.Comparisons(Scope{})
+		if err != nil {
+			t.Fatalf("failed to get comparisons: %s", err)
+		}
+		if len(comparisons) != 3 {
+			t.Fatalf("expected 3 comparisons, found %d", len(comparisons))
+		}
+		for _, comparison := range(comparisons) {
+			if comparison.Winner.Id != ids[0] {
+				t.Errorf("expected %d to win every comparison, found %d", ids[0], comparison.Winner.Id)
+			}
+		}
+	})
+
+	t.Run("complete ranking records every pair", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		ids := []int64{
+			insertMedia(s, "a", "a", t),
+			insertMedia(s, "b", "b", t),
+			insertMedia(s, "c", "c", t),
+			insertMedia(s, "d", "d", t),
+		}
+		if err := s.RecordRanking(ids, true); err != nil {
+			t.Fatalf("failed to record ranking: %s", err)
+		}
+		count, err := s.ComparisonCount()
+		if err != nil {
+			t.Fatalf("failed to count comparisons: %s", err)
+		}
+		if count != 6 {
+			t.Errorf("expected 6 comparisons, found %d", count)
+		}
+		list, err := s.SortedList(true, Scope{})
+		if err != nil {
+			t.Fatalf("failed to get sorted list: %s", err)
+		}
+		for i, media := range(list) {
+			if media.Id != ids[i] {
+				t.Errorf("expected %d at rank %d, found %d", ids[i], i, media.Id)
+			}
+		}
+	})
+
+	t.Run("rejects duplicates", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		a := insertMedia(s, "a", "a", t)
+		b := insertMedia(s, "b", "b", t)
+		if err := s.RecordRanking([]int64{ a, b, a }, true); err == nil {
+			t.Errorf("expected ranking with a duplicate to fail")
+		}
+	})
+
+	t.Run("undo takes back the whole vote", func(t *testing.T) {
+		s := newServer(":memory:", t)
+		a := insertMedia(s, "a", "a", t)
+		b := insertMedia(s, "b", "b", t)
+		c := insertMedia(s, "c", "c", t)
+		updateScores(s, b, a, t)
+		before := getMediaInfo(s, a, t)
+		if err := s.RecordRanking([]int64{ a, b, c }, true); err != nil {
+			t.Fatalf("failed to record ranking: %s", err)
+		}
+		if err := s.UndoLastComparison(); err != nil {
+			t.Fatalf("failed to undo: %s", err)
+		}
+		count, err := s.ComparisonCount()
+		if err != nil {
+			t.Fatalf("failed to count comparisons: %s", err)
+		}
+		if count != 1 {
+			t.Errorf("expected only the earlier comparison to remain, found %d", count)
+		}
+		compareMediaInfo("a", before, getMediaInfo(s, a, t), t)
+		if media := getMediaInfo(s, c, t); media.Matches != 0 || media.Score != initialScore {
+			t.Errorf("expected c to be back at the start, found %+v", media)
+		}
+	})
+}
+
+// benchmarkMediaCount is the size of library the selection benchmarks
+// are run against
+const benchmarkMediaCount = 200_000
+
+func newBenchmarkServer(b *testing.B) *Server {
+	s, err := NewServer(":memory:")
+	if err != nil {
+		b.Fatalf("failed to create new server: %s", err)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		b.Fatalf("failed to begin transaction: %s", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO media(path, sha1sum, score, matches) VALUES (?, ?, 1500, 0)")
+	if err != nil {
+		b.Fatalf("failed to prepare insert: %s", err)
+	}
+	for i := 0; i < benchmarkMediaCount; i++ {
+		name := fmt.Sprintf("%d", i)
+		if _, err := stmt.Exec(name, name); err != nil {
+			b.Fatalf("failed to insert media: %s", err)
+		}
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		b.Fatalf("failed to commit: %s", err)
+	}
+	ids, err := s.mediaIds()
+	if err != nil {
+		b.Fatalf("failed to load media ids: %s", err)
+	}
+	s.index = newMediaIndex(ids)
+	return s
+}
+
+func BenchmarkSelectMediaForComparison(b *testing.B) {
+	s := newBenchmarkServer(b)
+	for _, name := range([]string{ "random", "active", "coverage" }) {
+		selector, err := NewSelector(name)
+		if err != nil {
+			b.Fatalf("failed to create selector: %s", err)
+		}
+		s.selector = selector
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.SelectMediaForComparison(SelectOptions{}); err != nil {
+					b.Fatalf("failed to select media for comparison: %s", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkOrderByRandom is the full table scan selection used to do,
+// for comparison
+func BenchmarkOrderByRandom(b *testing.B) {
+	s := newBenchmarkServer(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rows, err := s.db.Query("SELECT id FROM media ORDER BY RANDOM() LIMIT 2")
+		if err != nil {
+			b.Fatalf("failed to query: %s", err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Thumbnails are scaled down copies of images for the list and history
+// tiles, cached on disk by sha1sum so they're made once per content.
+// Only formats the standard library decodes are thumbnailed, the
+// others are served in full. Videos get a poster frame instead, made
+// with ffmpeg when it's installed.
+
+const (
+	thumbnailDir = ".thumbnails"
+	// thumbnailSize is the longest side of a thumbnail, twice the
+	// size of a tile to stay sharp on high density screens
+	thumbnailSize = 400
+)
+
+// ffmpegPath is where ffmpeg was found to make video posters with, or
+// "" if it isn't installed
+var ffmpegPath, _ = exec.LookPath("ffmpeg")
+
+// thumbnailFile is the name a thumbnail of media is cached as, and
+// whether media can be thumbnailed at all. Media that may have
+// transparency keeps it in a png.
+func thumbnailFile(path, sha1 string) (string, bool) {
+	if isVideoFile(path) {
+		return sha1 + ".jpg", ffmpegPath != ""
+	}
+	switch mediaExt(path) {
+	case "jpg", "jpeg":
+		return sha1 + ".jpg", true
+	case "png", "gif":
+		return sha1 + ".png", true
+	}
+	return "", false
+}
+
+// makeThumbnail writes the thumbnail of media to dir from its content
+// in data, unless it's already cached, and returns its path
+func makeThumbnail(dir string, path, sha1 string, data []byte) (string, error) {
+	name, ok := thumbnailFile(path, sha1)
+	if !ok || isVideoFile(path) {
+		return "", fmt.Errorf("makeThumbnail: no thumbnails for \"%s\"", path)
+	}
+	thumbPath := filepath.Join(dir, name)
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("makeThumbnail decode \"%s\": %w", path, err)
+	}
+	thumb := scaleImage(orientImage(src, jpegOrientation(data)), thumbnailSize)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("makeThumbnail create directory: %w", err)
+	}
+	// written under a temporary name and renamed so a thumbnail being
+	// made is never served half written
+	f, err := os.CreateTemp(dir, "thumb-*")
+	if err != nil {
+		return "", fmt.Errorf("makeThumbnail create file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if filepath.Ext(name) == ".png" {
+		err = png.Encode(f, thumb)
+	} else {
+		err = jpeg.Encode(f, thumb, &jpeg.Options{ Quality: 85 })
+	}
+	if err != nil {
+		f.Close()
+		return "", fmt.Errorf("makeThumbnail encode \"%s\": %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("makeThumbnail close file: %w", err)
+	}
+	if err := os.Rename(f.Name(), thumbPath); err != nil {
+		return "", fmt.Errorf("makeThumbnail rename file: %w", err)
+	}
+	return thumbPath, nil
+}
+
+// Thumbnail returns the path of the cached thumbnail of media, making
+// it first if needed
+func (s *Server) Thumbnail(media MediaInfo) (string, error) {
+	name, ok := thumbnailFile(media.Path, media.Sha1)
+	if !ok {
+		return "", fmt.Errorf("Thumbnail: no thumbnails for \"%s\"", media.Path)
+	}
+	thumbPath := filepath.Join(s.thumbnails, name)
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath, nil
+	}
+	if isVideoFile(media.Path) {
+		return makePoster(s.thumbnails, media.Path, media.Sha1)
+	}
+	data, err := os.ReadFile(media.Path)
+	if err != nil {
+		return "", fmt.Errorf("Thumbnail read \"%s\": %w", media.Path, err)
+	}
+	return makeThumbnail(s.thumbnails, media.Path, media.Sha1, data)
+}
+
+// makePoster writes the poster of a video to dir, its frame at 0.1s
+// scaled down like a thumbnail, unless it's already cached, and returns
+// its path. Posters are only made when asked for, running ffmpeg over
+// every video would slow the scan down.
+func makePoster(dir string, path, sha1 string) (string, error) {
+	name, ok := thumbnailFile(path, sha1)
+	if !ok || !isVideoFile(path) {
+		return "", fmt.Errorf("makePoster: no poster for \"%s\"", path)
+	}
+	posterPath := filepath.Join(dir, name)
+	if _, err := os.Stat(posterPath); err == nil {
+		return posterPath, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("makePoster create directory: %w", err)
+	}
+	// ffmpeg picks the format by the extension of the file it writes
+	f, err := os.CreateTemp(dir, "poster-*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("makePoster create file: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	scale := fmt.Sprintf("scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease", thumbnailSize, thumbnailSize)
+	cmd := exec.Command(ffmpegPath, "-v", "error", "-y", "-ss", "0.1", "-i", path, "-frames:v", "1", "-vf", scale, f.Name())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("makePoster \"%s\": %w: %s", path, err, out)
+	}
+	if err := os.Rename(f.Name(), posterPath); err != nil {
+		return "", fmt.Errorf("makePoster rename file: %w", err)
+	}
+	return posterPath, nil
+}
+
+// scaleImage scales src down to fit in a size by size square,
+// averaging the pixels that fall in each pixel of the result. Images
+// that already fit are returned as they are.
+func scaleImage(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return src
+	}
+	dstWidth, dstHeight := size, height * size / width
+	if height > width {
+		dstWidth, dstHeight = width * size / height, size
+	}
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y * height / dstHeight
+		y1 := bounds.Min.Y + (y + 1) * height / dstHeight
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x * width / dstWidth
+			x1 := bounds.Min.X + (x + 1) * width / dstWidth
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r + uint64(cr), g + uint64(cg), b + uint64(cb), a + uint64(ca)
+					n++
+				}
+			}
+			// averaged premultiplied, then converted back
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of the jpeg in data, 1
+// when it has none. Cameras store photos as the sensor saw them and
+// leave turning them upright to the viewer through this tag.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for pos := 2; pos + 4 <= len(data) && data[pos] == 0xff; {
+		marker := data[pos + 1]
+		length := int(binary.BigEndian.Uint16(data[pos + 2:]))
+		// the image data follows the start of scan, no tags after it
+		if marker == 0xda || length < 2 || pos + 2 + length > len(data) {
+			return 1
+		}
+		segment := data[pos + 4:pos + 2 + length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag out of the first image
+// directory of the TIFF structure in exif
+func exifOrientation(exif []byte) int {
+	if len(exif) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	dir := int(order.Uint32(exif[4:]))
+	if dir < 8 || dir + 2 > len(exif) {
+		return 1
+	}
+	entries := int(order.Uint16(exif[dir:]))
+	for i := 0; i < entries; i++ {
+		entry := dir + 2 + i * 12
+		if entry + 12 > len(exif) {
+			return 1
+		}
+		// a SHORT, stored in the first bytes of the value field
+		if order.Uint16(exif[entry:]) == 0x0112 && order.Uint16(exif[entry + 2:]) == 3 {
+			orientation := int(order.Uint16(exif[entry + 8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// orientImage turns src upright according to an EXIF orientation.
+// Orientations 5 to 8 swap the width and height.
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			// where in src the pixel at x, y of the upright image is
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = width - 1 - x, y
+			case 3:
+				sx, sy = width - 1 - x, height - 1 - y
+			case 4:
+				sx, sy = x, height - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, height - 1 - x
+			case 7:
+				sx, sy = width - 1 - y, height - 1 - x
+			case 8:
+				sx, sy = width - 1 - y, x
+			}
+			dst.Set(x, y, src.At(bounds.Min.X + sx, bounds.Min.Y + sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestThumbnails(t *testing.T) {
+	dir := t.TempDir()
+	src := image.NewNRGBA(image.Rect(0, 0, 1000, 500))
+	for y := 0; y < 500; y++ {
+		for x := 0; x < 1000; x++ {
+			src.Set(x, y, color.NRGBA{ R: 255, A: 255 })
+		}
+	}
+	var data bytes.Buffer
+	if err := png.Encode(&data, src); err != nil {
+		t.Fatalf("failed to encode image: %s", err)
+	}
+	mediaPath := filepath.Join(dir, "wide.png")
+	if err := os.WriteFile(mediaPath, data.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write image: %s", err)
+	}
+
+	s := newServer(":memory:", t)
+	s.thumbnails = filepath.Join(dir, thumbnailDir)
+	id := insertMedia(s, mediaPath, "abc", t)
+
+	thumbPath, err := s.Thumbnail(getMediaInfo(s, id, t))
+	if err != nil {
+		t.Fatalf("failed to make thumbnail: %s", err)
+	}
+	if thumbPath != filepath.Join(s.thumbnails, "abc.png") {
+		t.Errorf("expected the thumbnail to be named by sha1sum, found %s", thumbPath)
+	}
+	f, err := os.Open(thumbPath)
+	if err != nil {
+		t.Fatalf("failed to open thumbnail: %s", err)
+	}
+	thumb, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to decode thumbnail: %s", err)
+	}
+	if size := thumb.Bounds().Size(); size.X != thumbnailSize || size.Y != thumbnailSize / 2 {
+		t.Errorf("expected a %dx%d thumbnail, found %v", thumbnailSize, thumbnailSize / 2, size)
+	}
+	if r, g, b, a := thumb.At(10, 10).RGBA(); r != 0xffff || g != 0 || b != 0 || a != 0xffff {
+		t.Errorf("expected the thumbnail to stay red, found %d %d %d %d", r, g, b, a)
+	}
+
+	// cached thumbnails aren't made again, even if the media is gone
+	if err := os.Remove(mediaPath); err != nil {
+		t.Fatalf("failed to remove image: %s", err)
+	}
+	if _, err := s.Thumbnail(getMediaInfo(s, id, t)); err != nil {
+		t.Errorf("expected the cached thumbnail to be used: %s", err)
+	}
+
+	defer func(path string) { ffmpegPath = path }(ffmpegPath)
+	ffmpegPath = ""
+	if _, ok := thumbnailFile("clip.mp4", "def"); ok {
+		t.Errorf("expected videos to have no poster without ffmpeg")
+	}
+	ffmpegPath = "ffmpeg"
+	if name, ok := thumbnailFile("clip.mp4", "def"); !ok || name != "def.jpg" {
+		t.Errorf("expected a def.jpg poster with ffmpeg, found %q, %t", name, ok)
+	}
+	small := image.NewNRGBA(image.Rect(0, 0, 50, 80))
+	if scaleImage(small, thumbnailSize) != image.Image(small) {
+		t.Errorf("expected images that fit to be left alone")
+	}
+}
+
+func TestPosters(t *testing.T) {
+	if ffmpegPath == "" {
+		t.Skip("ffmpeg isn't installed")
+	}
+	dir := t.TempDir()
+	clip := filepath.Join(dir, "clip.mp4")
+	out, err := exec.Command(ffmpegPath, "-v", "error", "-f", "lavfi", "-i", "color=c=red:s=1280x720:d=1", clip).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to make video: %s: %s", err, out)
+	}
+
+	s := newServer(":memory:", t)
+	s.thumbnails = filepath.Join(dir, thumbnailDir)
+	id := insertMedia(s, clip, "abc", t)
+	posterPath, err := s.Thumbnail(getMediaInfo(s, id, t))
+	if err != nil {
+		t.Fatalf("failed to make poster: %s", err)
+	}
+	f, err := os.Open(posterPath)
+	if err != nil {
+		t.Fatalf("failed to open poster: %s", err)
+	}
+	poster, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to decode poster: %s", err)
+	}
+	if size := poster.Bounds().Size(); size.X != thumbnailSize || size.Y >= size.X {
+		t.Errorf("expected a wide poster %d wide, found %v", thumbnailSize, size)
+	}
+}
+
+func TestThumbnailOrientation(t *testing.T) {
+	// red on the left and blue on the right, as the sensor saw it
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 100; x++ {
+			if x < 50 {
+				src.Set(x, y, color.NRGBA{ R: 255, A: 255 })
+			} else {
+				src.Set(x, y, color.NRGBA{ B: 255, A: 255 })
+			}
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, src, nil); err != nil {
+		t.Fatalf("failed to encode image: %s", err)
+	}
+
+	// an APP1 segment tagging the photo as to be turned clockwise
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	exif = append(exif, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00)
+	exif = append(exif, 0x00, 0x00, 0x00, 0x00)
+	data := []byte{ 0xff, 0xd8, 0xff, 0xe1 }
+	data = binary.BigEndian.AppendUint16(data, uint16(len(exif) + 2))
+	data = append(data, exif...)
+	data = append(data, encoded.Bytes()[2:]...)
+
+	if orientation := jpegOrientation(data); orientation != 6 {
+		t.Fatalf("expected orientation 6, found %d", orientation)
+	}
+	if orientation := jpegOrientation(encoded.Bytes()); orientation != 1 {
+		t.Errorf("expected jpegs without exif to be upright, found %d", orientation)
+	}
+
+	dir := t.TempDir()
+	thumbPath, err := makeThumbnail(dir, "photo.jpg", "abc", data)
+	if err != nil {
+		t.Fatalf("failed to make thumbnail: %s", err)
+	}
+	f, err := os.Open(thumbPath)
+	if err != nil {
+		t.Fatalf("failed to open thumbnail: %s", err)
+	}
+	thumb, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to decode thumbnail: %s", err)
+	}
+	if size := thumb.Bounds().Size(); size.X != 40 || size.Y != 100 {
+		t.Fatalf("expected a 40x100 thumbnail, found %v", size)
+	}
+	if r, _, b, _ := thumb.At(20, 10).RGBA(); r < 0xc000 || b > 0x4000 {
+		t.Errorf("expected red on top, found %d %d", r, b)
+	}
+	if r, _, b, _ := thumb.At(20, 90).RGBA(); b < 0xc000 || r > 0x4000 {
+		t.Errorf("expected blue at the bottom, found %d %d", r, b)
+	}
+
+	// where the top left pixel ends up in the upright image
+	corners := map[int]image.Point{
+		2: { 2, 0 }, 3: { 2, 1 }, 4: { 0, 1 }, 5: { 0, 0 },
+		6: { 1, 0 }, 7: { 1, 2 }, 8: { 0, 2 },
+	}
+	small := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	small.Set(0, 0, color.NRGBA{ R: 255, A: 255 })
+	for orientation, corner := range(corners) {
+		upright := orientImage(small, orientation)
+		if r, _, _, _ := upright.At(corner.X, corner.Y).RGBA(); r != 0xffff {
+			t.Errorf("expected orientation %d to move the corner to %v", orientation, corner)
+		}
+	}
+}
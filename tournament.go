@@ -0,0 +1,556 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A Swiss-system tournament runs a fixed number of rounds. Each round
+// pairs entrants with similar records who haven't met yet, so after a
+// handful of rounds the standings are a sound ranking of the entrants.
+// Every match is also recorded as a regular comparison.
+
+type Tournament struct {
+	Id int64
+	Name string
+	Rounds int
+	// Round is the number of the round being played
+	Round int
+	Finished bool
+	Entrants int
+}
+
+type TournamentMatch struct {
+	Id int64
+	TournamentId int64
+	Round int
+	Media1 MediaInfo
+	Media2 MediaInfo
+}
+
+// Standing is an entrant's record in a tournament
+type Standing struct {
+	MediaInfo
+	// Points are 1 per win or bye and 0.5 per draw
+	Points float64
+	Wins int
+	Draws int
+	Losses int
+	Byes int
+	// Buchholz is the sum of the entrant's opponents' points, the
+	// first tie-break
+	Buchholz float64
+}
+
+var TournamentFinishedError = errors.New("tournament is finished")
+
+// maxTournamentSize caps the entrants of a tournament, so size 0 on a
+// large library doesn't enter all of it in one transaction
+const maxTournamentSize = 256
+
+// maxPairingSteps bounds the search for a pairing when the greedy pass
+// can't find one, across every choice of bye in a round
+const maxPairingSteps = 100000
+
+// CreateTournament starts a tournament of rounds rounds between size
+// random media, or maxTournamentSize if size is 0 or larger, and pairs
+// the first round.
+func (s *Server) CreateTournament(name string, rounds int, size int) (int64, error) {
+	if size <= 0 || size > maxTournamentSize {
+		size = maxTournamentSize
+	}
+	entrants := s.index.Sample(size)
+	if len(entrants) < 2 {
+		return 0, NotEnoughMediaError
+	}
+	if rounds < 1 || rounds >= len(entrants) {
+		return 0, fmt.Errorf("CreateTournament: %d entrants can play between 1 and %d rounds without rematches", len(entrants), len(entrants) - 1)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("CreateTournament create new transaction: %w", err)
+	}
+
+	result, err := tx.Exec("INSERT INTO tournaments(name, rounds) VALUES (?, ?)", name, rounds)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("CreateTournament insert tournament: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("CreateTournament get tournament id: %w", err)
+	}
+
+	for _, mediaId := range(entrants) {
+		if _, err := tx.Exec("INSERT INTO tournament_entries(tournament_id, media_id) VALUES (?, ?)", id, mediaId); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("CreateTournament insert entry: %w", err)
+		}
+	}
+
+	if err := pairNextRound(tx, id); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("CreateTournament: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("CreateTournament commit transaction: %w", err)
+	}
+
+	return id, nil
+}
+
+const tournamentQuery = `
+SELECT
+  t.id, t.name, t.rounds, t.finished,
+  (SELECT COALESCE(MAX(number), 0) FROM tournament_rounds WHERE tournament_id = t.id),
+  (SELECT COUNT(*) FROM tournament_entries WHERE tournament_id = t.id)
+FROM tournaments t
+`
+
+func scanTournament(row rowScanner) (Tournament, error) {
+	var t Tournament
+	err := row.Scan(&t.Id, &t.Name, &t.Rounds, &t.Finished, &t.Round, &t.Entrants)
+	return t, err
+}
+
+func (s *Server) GetTournament(id int64) (Tournament, error) {
+	t, err := scanTournament(s.db.QueryRow(tournamentQuery + "WHERE t.id = ?", id))
+	if err != nil {
+		return Tournament{}, fmt.Errorf("GetTournament scan row: %w", err)
+	}
+	return t, nil
+}
+
+func (s *Server) Tournaments() ([]Tournament, error) {
+	rows, err := s.db.Query(tournamentQuery + "ORDER BY t.id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("Tournaments query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var tournaments []Tournament
+	for rows.Next() {
+		t, err := scanTournament(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Tournaments scan row: %w", err)
+		}
+		tournaments = append(tournaments, t)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("Tournaments rows: %w", rows.Err())
+	}
+
+	return tournaments, nil
+}
+
+// NextTournamentMatch returns the next unplayed match of the current
+// round, or TournamentFinishedError.
+func (s *Server) NextTournamentMatch(tournamentId int64) (TournamentMatch, error) {
+	row := s.db.QueryRow(`
+SELECT m.id, r.number, m.media1_id, m.media2_id
+FROM tournament_matches m
+JOIN tournament_rounds r ON m.round_id = r.id
+WHERE r.tournament_id = ? AND m.outcome IS NULL
+ORDER BY r.number, m.id
+LIMIT 1`, tournamentId)
+	match := TournamentMatch{ TournamentId: tournamentId }
+	var media1Id, media2Id int64
+	if err := row.Scan(&match.Id, &match.Round, &media1Id, &media2Id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TournamentMatch{}, TournamentFinishedError
+		}
+		return TournamentMatch{}, fmt.Errorf("NextTournamentMatch scan row: %w", err)
+	}
+
+	var err error
+	if match.Media1, err = s.GetMediaInfo(media1Id); err != nil {
+		return TournamentMatch{}, fmt.Errorf("NextTournamentMatch: %w", err)
+	}
+	if match.Media2, err = s.GetMediaInfo(media2Id); err != nil {
+		return TournamentMatch{}, fmt.Errorf("NextTournamentMatch: %w", err)
+	}
+
+	return match, nil
+}
+
+// RecordTournamentResult records the result of a tournament match as a
+// comparison, and pairs the next round once every match of the current
+// one is played. On a draw winnerId may be either media.
+func (s *Server) RecordTournamentResult(tournamentId, matchId, winnerId int64, outcome Outcome) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("RecordTournamentResult create new transaction: %w", err)
+	}
+
+	row := tx.QueryRow(`
+SELECT m.media1_id, m.media2_id, m.outcome IS NOT NULL
+FROM tournament_matches m
+JOIN tournament_rounds r ON m.round_id = r.id
+WHERE m.id = ? AND r.tournament_id = ?`, matchId, tournamentId)
+	var media1Id, media2Id int64
+	var played bool
+	if err := row.Scan(&media1Id, &media2Id, &played); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordTournamentResult find match %d: %w", matchId, err)
+	}
+	if played {
+		tx.Rollback()
+		return fmt.Errorf("RecordTournamentResult match %d was already played", matchId)
+	}
+
+	var loserId int64
+	switch winnerId {
+	case media1Id:
+		loserId = media2Id
+	case media2Id:
+		loserId = media1Id
+	default:
+		tx.Rollback()
+		return fmt.Errorf("RecordTournamentResult media %d isn't in match %d", winnerId, matchId)
+	}
+
+	comparisonId, err := s.updateScoresTx(tx, globalLeaderboard, winnerId, loserId, outcome)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordTournamentResult: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"UPDATE tournament_matches SET winner_id = ?, outcome = ?, comparison_id = ? WHERE id = ?",
+		winnerId, outcome, comparisonId, matchId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordTournamentResult update match: %w", err)
+	}
+
+	var remaining int
+	row = tx.QueryRow(`
+SELECT COUNT(*) FROM tournament_matches m
+JOIN tournament_rounds r ON m.round_id = r.id
+WHERE r.tournament_id = ? AND m.outcome IS NULL`, tournamentId)
+	if err := row.Scan(&remaining); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("RecordTournamentResult count remaining matches: %w", err)
+	}
+	if remaining == 0 {
+		if err := pairNextRound(tx, tournamentId); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("RecordTournamentResult: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("RecordTournamentResult commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) TournamentStandings(tournamentId int64) ([]Standing, error) {
+	standings, _, _, err := tournamentRecords(s.db, tournamentId)
+	return standings, err
+}
+
+// tournamentRecords returns the sorted standings of a tournament, which
+// pairs of entrants already met and which entrants had a bye.
+func tournamentRecords(db querier, tournamentId int64) ([]Standing, map[[2]int64]bool, map[int64]bool, error) {
+	rows, err := db.Query(
+		"SELECT " + mediaColumns + " FROM media WHERE id IN (SELECT media_id FROM tournament_entries WHERE tournament_id = ?)",
+		tournamentId,
+	)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tournament records query entrants: %w", err)
+	}
+	entrants, err := scanMediaList(rows, 0)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tournament records: %w", err)
+	}
+
+	records := make(map[int64]*Standing, len(entrants))
+	for _, media := range(entrants) {
+		records[media.Id] = &Standing{ MediaInfo: media }
+	}
+
+	played := make(map[[2]int64]bool)
+	opponents := make(map[int64][]int64)
+	rows, err = db.Query(`
+SELECT m.media1_id, m.media2_id, m.winner_id, m.outcome
+FROM tournament_matches m
+JOIN tournament_rounds r ON m.round_id = r.id
+WHERE r.tournament_id = ?`, tournamentId)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tournament records query matches: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var media1Id, media2Id int64
+		var winnerId sql.NullInt64
+		var outcome sql.NullInt64
+		if err := rows.Scan(&media1Id, &media2Id, &winnerId, &outcome); err != nil {
+			return nil, nil, nil, fmt.Errorf("tournament records scan match: %w", err)
+		}
+		played[[2]int64{ media1Id, media2Id }] = true
+		played[[2]int64{ media2Id, media1Id }] = true
+		if !outcome.Valid {
+			continue
+		}
+		media1, media2 := records[media1Id], records[media2Id]
+		if media1 == nil || media2 == nil {
+			continue
+		}
+		opponents[media1Id] = append(opponents[media1Id], media2Id)
+		opponents[media2Id] = append(opponents[media2Id], media1Id)
+		switch {
+		case Outcome(outcome.Int64) == OutcomeDraw:
+			media1.Draws++
+			media2.Draws++
+			media1.Points += 0.5
+			media2.Points += 0.5
+		case winnerId.Int64 == media1Id:
+			media1.Wins++
+			media2.Losses++
+			media1.Points++
+		default:
+			media2.Wins++
+			media1.Losses++
+			media2.Points++
+		}
+	}
+	if rows.Err() != nil {
+		return nil, nil, nil, fmt.Errorf("tournament records match rows: %w", rows.Err())
+	}
+	rows.Close()
+
+	byes := make(map[int64]bool)
+	rows, err = db.Query("SELECT bye_media_id FROM tournament_rounds WHERE tournament_id = ? AND bye_media_id IS NOT NULL", tournamentId)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tournament records query byes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mediaId int64
+		if err := rows.Scan(&mediaId); err != nil {
+			return nil, nil, nil, fmt.Errorf("tournament records scan bye: %w", err)
+		}
+		byes[mediaId] = true
+		if record := records[mediaId]; record != nil {
+			record.Byes++
+			record.Points++
+		}
+	}
+	if rows.Err() != nil {
+		return nil, nil, nil, fmt.Errorf("tournament records bye rows: %w", rows.Err())
+	}
+
+	standings := make([]Standing, 0, len(records))
+	for id, record := range(records) {
+		for _, opponent := range(opponents[id]) {
+			record.Buchholz += records[opponent].Points
+		}
+		standings = append(standings, *record)
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Id < b.Id
+	})
+
+	return standings, played, byes, nil
+}
+
+// pairNextRound pairs the next round of a tournament, or marks it
+// finished if every round was played or no pairing without rematches
+// is left.
+func pairNextRound(tx *sql.Tx, tournamentId int64) error {
+	var rounds, round int
+	row := tx.QueryRow(
+		"SELECT rounds, (SELECT COALESCE(MAX(number), 0) FROM tournament_rounds WHERE tournament_id = ?) FROM tournaments WHERE id = ?",
+		tournamentId, tournamentId,
+	)
+	if err := row.Scan(&rounds, &round); err != nil {
+		return fmt.Errorf("pair next round scan tournament: %w", err)
+	}
+
+	standings, played, byes, err := tournamentRecords(tx, tournamentId)
+	if err != nil {
+		return fmt.Errorf("pair next round: %w", err)
+	}
+	ids := make([]int64, len(standings))
+	for i, standing := range(standings) {
+		ids[i] = standing.Id
+	}
+
+	var pairs [][2]int64
+	var bye int64
+	ok := false
+	if round < rounds {
+		pairs, bye, ok = swissPairings(ids, played, byes)
+	}
+	if !ok {
+		if _, err := tx.Exec("UPDATE tournaments SET finished = true WHERE id = ?", tournamentId); err != nil {
+			return fmt.Errorf("pair next round finish tournament: %w", err)
+		}
+		return nil
+	}
+
+	byeId := sql.NullInt64{ Int64: bye, Valid: bye != 0 }
+	result, err := tx.Exec("INSERT INTO tournament_rounds(tournament_id, number, bye_media_id) VALUES (?, ?, ?)", tournamentId, round + 1, byeId)
+	if err != nil {
+		return fmt.Errorf("pair next round insert round: %w", err)
+	}
+	roundId, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("pair next round get round id: %w", err)
+	}
+	for _, pair := range(pairs) {
+		if _, err := tx.Exec("INSERT INTO tournament_matches(round_id, media1_id, media2_id) VALUES (?, ?, ?)", roundId, pair[0], pair[1]); err != nil {
+			return fmt.Errorf("pair next round insert match: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// swissPairings pairs ids, ordered by standing, with the nearest
+// entrant they haven't played. With an odd number of entrants the
+// lowest ranked entrant without a bye sits the round out. It returns
+// false if no pairing avoids a rematch.
+func swissPairings(ids []int64, played map[[2]int64]bool, byes map[int64]bool) ([][2]int64, int64, bool) {
+	steps := maxPairingSteps
+	if len(ids) % 2 == 0 {
+		pairs, ok := pairWithoutRematches(ids, played, &steps)
+		return pairs, 0, ok
+	}
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		if byes[ids[i]] {
+			continue
+		}
+		rest := make([]int64, 0, len(ids) - 1)
+		rest = append(rest, ids[:i]...)
+		rest = append(rest, ids[i + 1:]...)
+		if pairs, ok := pairWithoutRematches(rest, played, &steps); ok {
+			return pairs, ids[i], true
+		}
+	}
+	return nil, 0, false
+}
+
+// pairWithoutRematches pairs each of ids with the nearest one after it
+// they haven't played. If an entrant is left with only rematches it
+// swaps into an earlier pair, and if that fails too it falls back to a
+// search that gives up once steps runs out.
+func pairWithoutRematches(ids []int64, played map[[2]int64]bool, steps *int) ([][2]int64, bool) {
+	if pairs, ok := greedyPairs(ids, played); ok {
+		return pairs, true
+	}
+	return searchPairs(ids, played, steps)
+}
+
+func greedyPairs(ids []int64, played map[[2]int64]bool) ([][2]int64, bool) {
+	paired := make([]bool, len(ids))
+	pairs := make([][2]int64, 0, len(ids) / 2)
+	ok := true
+	for i := range(ids) {
+		if paired[i] {
+			continue
+		}
+		paired[i] = true
+		j := i + 1
+		for j < len(ids) && (paired[j] || played[[2]int64{ ids[i], ids[j] }]) {
+			j++
+		}
+		if j < len(ids) {
+			paired[j] = true
+			pairs = append(pairs, [2]int64{ ids[i], ids[j] })
+			continue
+		}
+		if pairs, ok = swapIntoPair(pairs, ids, paired, i, played); !ok {
+			return nil, false
+		}
+	}
+	return pairs, true
+}
+
+// swapIntoPair pairs ids[i] with one side of an earlier pair, nearest
+// first, and the other side with an entrant still unpaired
+func swapIntoPair(pairs [][2]int64, ids []int64, paired []bool, i int, played map[[2]int64]bool) ([][2]int64, bool) {
+	for p := len(pairs) - 1; p >= 0; p-- {
+		for side := 0; side < 2; side++ {
+			keep, other := pairs[p][side], pairs[p][1 - side]
+			if played[[2]int64{ keep, ids[i] }] {
+				continue
+			}
+			for j := i + 1; j < len(ids); j++ {
+				if paired[j] || played[[2]int64{ other, ids[j] }] {
+					continue
+				}
+				paired[j] = true
+				pairs[p] = [2]int64{ keep, ids[i] }
+				return append(pairs, [2]int64{ other, ids[j] }), true
+			}
+		}
+	}
+	return pairs, false
+}
+
+// searchPairs backtracks through every pairing, in the order the greedy
+// pass tries them, taking one of steps for each pair tried or undone
+func searchPairs(ids []int64, played map[[2]int64]bool, steps *int) ([][2]int64, bool) {
+	type choice struct {
+		first int
+		partner int
+	}
+	used := make([]bool, len(ids))
+	stack := make([]choice, 0, len(ids) / 2)
+	first, start := 0, 0
+	for ; *steps > 0; *steps-- {
+		for first < len(ids) && used[first] {
+			first++
+		}
+		if first == len(ids) {
+			pairs := make([][2]int64, len(stack))
+			for i, c := range(stack) {
+				pairs[i] = [2]int64{ ids[c.first], ids[c.partner] }
+			}
+			return pairs, true
+		}
+
+		partner := start
+		if partner <= first {
+			partner = first + 1
+		}
+		for partner < len(ids) && (used[partner] || played[[2]int64{ ids[first], ids[partner] }]) {
+			partner++
+		}
+		if partner < len(ids) {
+			used[first], used[partner] = true, true
+			stack = append(stack, choice{ first, partner })
+			start = 0
+			continue
+		}
+
+		if len(stack) == 0 {
+			return nil, false
+		}
+		last := stack[len(stack) - 1]
+		stack = stack[:len(stack) - 1]
+		used[last.first], used[last.partner] = false, false
+		first, start = last.first, last.partner + 1
+	}
+	return nil, false
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSwissPairings(t *testing.T) {
+	t.Run("pairs neighbours in the standings", func(t *testing.T) {
+		pairs, bye, ok := swissPairings([]int64{ 1, 2, 3, 4 }, map[[2]int64]bool{}, map[int64]bool{})
+		if !ok || bye != 0 {
+			t.Fatalf("expected a pairing without a bye, found ok: %t, bye: %d", ok, bye)
+		}
+		expected := [][2]int64{{ 1, 2 }, { 3, 4 }}
+		if fmt.Sprint(pairs) != fmt.Sprint(expected) {
+			t.Errorf("expected pairs %v, found %v", expected, pairs)
+		}
+	})
+
+	t.Run("avoids rematches", func(t *testing.T) {
+		played := map[[2]int64]bool{ { 1, 2 }: true, { 2, 1 }: true }
+		pairs, _, ok := swissPairings([]int64{ 1, 2, 3, 4 }, played, map[int64]bool{})
+		if !ok {
+			t.Fatalf("expected a pairing")
+		}
+		expected := [][2]int64{{ 1, 3 }, { 2, 4 }}
+		if fmt.Sprint(pairs) != fmt.Sprint(expected) {
+			t.Errorf("expected pairs %v, found %v", expected, pairs)
+		}
+	})
+
+	t.Run("gives the bye to the lowest ranked without one", func(t *testing.T) {
+		_, bye, ok := swissPairings([]int64{ 1, 2, 3 }, map[[2]int64]bool{}, map[int64]bool{ 3: true })
+		if !ok || bye != 2 {
+			t.Errorf("expected bye for 2, found ok: %t, bye: %d", ok, bye)
+		}
+	})
+
+	t.Run("pairs a large field", func(t *testing.T) {
+		ids := make([]int64, 2000)
+		for i := range(ids) {
+			ids[i] = int64(i + 1)
+		}
+		played := map[[2]int64]bool{}
+		played2 := func(a, b int64) {
+			played[[2]int64{ a, b }] = true
+			played[[2]int64{ b, a }] = true
+		}
+		// neighbours already met, and the bottom six all met each other,
+		// so the nearest opponent is never free at the end of the field
+		for i := 1; i < len(ids); i++ {
+			played2(ids[i - 1], ids[i])
+		}
+		for i := len(ids) - 6; i < len(ids); i++ {
+			for j := i + 1; j < len(ids); j++ {
+				played2(ids[i], ids[j])
+			}
+		}
+
+		pairs, bye, ok := swissPairings(ids, played, map[int64]bool{})
+		if !ok || bye != 0 {
+			t.Fatalf("expected a pairing without a bye, found ok: %t, bye: %d", ok, bye)
+		}
+		seen := map[int64]bool{}
+		for _, pair := range(pairs) {
+			if played[pair] {
+				t.Errorf("pair %v is a rematch", pair)
+			}
+			if seen[pair[0]] || seen[pair[1]] {
+				t.Errorf("pair %v repeats an entrant", pair)
+			}
+			seen[pair[0]], seen[pair[1]] = true, true
+		}
+		if len(seen) != len(ids) {
+			t.Errorf("expected %d entrants paired, found %d", len(ids), len(seen))
+		}
+	})
+
+	t.Run("gives up on a large field with no pairing", func(t *testing.T) {
+		ids := make([]int64, 1000)
+		for i := range(ids) {
+			ids[i] = int64(i + 1)
+		}
+		// the last entrant has played everyone else
+		played := map[[2]int64]bool{}
+		last := ids[len(ids) - 1]
+		for _, id := range(ids[:len(ids) - 1]) {
+			played[[2]int64{ id, last }] = true
+			played[[2]int64{ last, id }] = true
+		}
+		if _, _, ok := swissPairings(ids, played, map[int64]bool{}); ok {
+			t.Errorf("expected pairing to fail")
+		}
+	})
+
+	t.Run("fails when every pairing is a rematch", func(t *testing.T) {
+		played := map[[2]int64]bool{ { 1, 2 }: true, { 2, 1 }: true }
+		if _, _, ok := swissPairings([]int64{ 1, 2 }, played, map[int64]bool{}); ok {
+			t.Errorf("expected pairing to fail")
+		}
+	})
+}
+
+func TestTournament(t *testing.T) {
+	s := newServer(":memory:", t)
+	for i := 0; i < 5; i++ {
+		insertMedia(s, fmt.Sprintf("%d", i), fmt.Sprintf("%d", i), t)
+	}
+
+	if _, err := s.CreateTournament("too long", 5, 0); err == nil {
+		t.Errorf("expected 5 rounds between 5 entrants to be rejected")
+	}
+
+	id, err := s.CreateTournament("test", 4, 0)
+	if err != nil {
+		t.Fatalf("failed to create tournament: %s", err)
+	}
+
+	played := make(map[[2]int64]bool)
+	matches := 0
+	for {
+		match, err := s.NextTournamentMatch(id)
+		if errors.Is(err, TournamentFinishedError) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to get next match: %s", err)
+		}
+		pair := [2]int64{ match.Media1.Id, match.Media2.Id }
+		if played[pair] {
+			t.Fatalf("rematch between %d and %d", pair[0], pair[1])
+		}
+		played[pair] = true
+		played[[2]int64{ pair[1], pair[0] }] = true
+
+		// the lower id always wins
+		winner := match.Media1.Id
+		if match.Media2.Id < winner {
+			winner = match.Media2.Id
+		}
+		if err := s.RecordTournamentResult(id, match.Id, winner, OutcomeWin); err != nil {
+			t.Fatalf("failed to record result: %s", err)
+		}
+		if err := s.RecordTournamentResult(id, match.Id, winner, OutcomeWin); err == nil {
+			t.Fatalf("expected recording a played match to fail")
+		}
+		matches++
+	}
+
+	// 4 rounds of 2 matches with a bye each
+	if matches != 8 {
+		t.Errorf("expected 8 matches, found %d", matches)
+	}
+	count, err := s.ComparisonCount()
+	if err != nil {
+		t.Fatalf("failed to count comparisons: %s", err)
+	}
+	if count != int64(matches) {
+		t.Errorf("expected %d comparisons, found %d", matches, count)
+	}
+
+	// match results can't be taken back from the face-off or history
+	if err := s.UndoLastComparison(); !errors.Is(err, MatchComparisonError) {
+		t.Errorf("expected undoing a match to fail with MatchComparisonError, found %v", err)
+	}
+	comparisons, err := s.Comparisons(Scope{})
+	if err != nil {
+		t.Fatalf("failed to get comparisons: %s", err)
+	}
+	if err := s.FlipComparison(comparisons[len(comparisons) - 1].Id); !errors.Is(err, MatchComparisonError) {
+		t.Errorf("expected flipping a match to fail with MatchComparisonError, found %v", err)
+	}
+
+	tournament, err := s.GetTournament(id)
+	if err != nil {
+		t.Fatalf("failed to get tournament: %s", err)
+	}
+	if !tournament.Finished || tournament.Round != 4 || tournament.Entrants != 5 {
+		t.Errorf("expected finished tournament after 4 rounds with 5 entrants, found %+v", tournament)
+	}
+
+	standings, err := s.TournamentStandings(id)
+	if err != nil {
+		t.Fatalf("failed to get standings: %s", err)
+	}
+	byes := 0
+	for i, standing := range(standings) {
+		if standing.Byes > 1 {
+			t.Errorf("expected media %d to have at most 1 bye, found %d", standing.Id, standing.Byes)
+		}
+		if standing.Wins + standing.Losses + standing.Draws + standing.Byes != 4 {
+			t.Errorf("expected media %d to play or sit out every round, found %+v", standing.Id, standing)
+		}
+		byes += standing.Byes
+		if i > 0 && standings[i - 1].Points < standing.Points {
+			t.Errorf("standings aren't sorted by points: %+v", standings)
+		}
+	}
+	if byes != 4 {
+		t.Errorf("expected 4 byes, found %d", byes)
+	}
+	if standings[0].Id != 1 || standings[0].Points != 4 {
+		t.Errorf("expected media 1 to lead with 4 points, found %+v", standings[0])
+	}
+}
@@ -19,7 +19,7 @@ const indexView = `
     grid-template-columns: 1fr 1fr;
     grid-gap: 1em;
   }
-  img {
+  img, video {
     max-width: 100%;
     max-height: 80vh;
     border-radius: 4px;
@@ -36,6 +36,9 @@ const indexView = `
   input[type=submit] {
     font-size: larger;
   }
+  form.draw {
+    grid-column: 1 / span 2;
+  }
   header {
     text-align: center;
     margin-bottom: 40px;
@@ -43,48 +46,287 @@ const indexView = `
   .info {
     text-align: center;
   }
+  .progress {
+    margin-top: 1em;
+    font-size: smaller;
+  }
+  .info form {
+    margin-top: 1em;
+  }
+  .info input[type=submit] {
+    font-size: medium;
+  }
+  .focus input[type=number] {
+    width: 5em;
+  }
+  .scope {
+    margin-top: 0.5em;
+  }
+  .audio {
+    display: flex;
+    flex-direction: column;
+    align-items: center;
+    gap: 10px;
+    padding: 2em;
+    border-radius: 4px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .audio .name {
+    font-weight: bold;
+    word-break: break-all;
+  }
+  .duration {
+    font-size: smaller;
+  }
 </style>
 </head>
 <body>
   <header>
     <h1>Media Rank</h1>
-    <div><a class="link" href="/list">Ranked List</a><a class="link" href="/history">History</a></div>
+    <div><a class="link" href="/list{{.ScopeQuery}}">Ranked List</a><a class="link" href="/multi">Best of 4</a><a class="link" href="/history{{.ScopeQuery}}">History</a><a class="link" href="/tournaments">Tournaments</a><a class="link" href="/brackets">Brackets</a><a class="link" href="/collections">Collections</a>{{if .Queued}}<a class="link" href="/placements">Placement Queue ({{.Queued}})</a>{{end}}</div>
+    {{with .Placement}}
+    <div class="progress">
+      Placing new media on the left, about {{.Remaining}} more votes
+    </div>
+    {{else}}{{if .MinMatches}}
+    <div class="progress">
+      <progress value="{{.Covered}}" max="{{.Total}}"></progress>
+      {{.Covered}} / {{.Total}} compared at least {{.MinMatches}} times
+    </div>
+    {{end}}{{end}}
   </header>
   <div class="selection">
     <div class="image">
-      <a href="/media/{{.Media1.Id}}" target="_blank">
-        <img src="/media/{{.Media1.Id}}" title="Id: {{.Media1.Id}}, Score: {{.Media1.Score}}, Path: {{.Media1.Path}}">
-      </a>
+      {{template "media" .Media1.Full}}
     </div>
     <div class="image">
-      <a href="/media/{{.Media2.Id}}" target="_blank">
-        <img src="/media/{{.Media2.Id}}" title="Id: {{.Media2.Id}}, Score: {{.Media2.Score}}, Path: {{.Media2.Path}}">
-      </a>
+      {{template "media" .Media2.Full}}
     </div>
-    <form action="/vote" method="POST">
+    <form action="/vote{{.Query}}" method="POST">
       <input type="hidden" name="loser" value="{{.Media2.Id}}">
       <input type="hidden" name="winner" value="{{.Media1.Id}}">
+      {{with .Placement}}<input type="hidden" name="placement" value="{{.Media.Id}}">{{end}}
       <input type="submit" value="Winner (a)" id="winnerLeft">
     </form>
-    <form action="/vote" method="POST">
+    <form action="/vote{{.Query}}" method="POST">
       <input type="hidden" name="loser" value="{{.Media1.Id}}">
       <input type="hidden" name="winner" value="{{.Media2.Id}}">
+      {{with .Placement}}<input type="hidden" name="placement" value="{{.Media.Id}}">{{end}}
       <input type="submit" value="Winner (d)" id="winnerRight">
     </form>
+    <form action="/vote{{.Query}}" method="POST" class="draw">
+      <input type="hidden" name="winner" value="{{.Media1.Id}}">
+      <input type="hidden" name="loser" value="{{.Media2.Id}}">
+      <input type="hidden" name="outcome" value="draw">
+      {{with .Placement}}<input type="hidden" name="placement" value="{{.Media.Id}}">{{end}}
+      <input type="submit" value="Draw (s)" id="draw">
+    </form>
   </div>
   <div class="info">
-    New Options (r)
+    <form action="/skip{{.Query}}" method="POST">
+      <input type="hidden" name="media1" value="{{.Media1.Id}}">
+      <input type="hidden" name="media2" value="{{.Media2.Id}}">
+      {{with .Placement}}<input type="hidden" name="placement" value="{{.Media.Id}}">{{end}}
+      <input type="submit" value="Can't Decide (r)" id="skip">
+    </form>
+    <form action="/vote/undo{{.Query}}" method="POST">
+      <input type="submit" value="Undo Last Vote (z)" id="undo">
+    </form>
+    <form action="/" method="GET" class="focus">
+      <label>Top <input type="number" name="top" min="2" value="{{with .Options.Top}}{{.}}{{end}}" title="Only compare the highest ranked media"></label>
+      or scores
+      <input type="number" name="min" value="{{with .Options.Band}}{{.Min}}{{end}}" placeholder="min">
+      to <input type="number" name="max" value="{{with .Options.Band}}{{.Max}}{{end}}" placeholder="max">
+      {{template "scope" .Options.Scope}}
+      <input type="submit" value="Focus">
+      {{if .Query}}<a href="/">Show All</a>{{end}}
+    </form>
   </div>
+  {{template "player-script"}}
   <script>
     const winnerLeft = document.getElementById('winnerLeft');
     const winnerRight = document.getElementById('winnerRight');
+    const draw = document.getElementById('draw');
+    const undo = document.getElementById('undo');
+    const skip = document.getElementById('skip');
     document.addEventListener('keypress', (e) => {
       if (e.key == "a") {
         winnerLeft.click()
       } else if (e.key == "d") {
         winnerRight.click()
+      } else if (e.key == "s") {
+        draw.click()
+      } else if (e.key == "z") {
+        undo.click()
       } else if (e.key == "r") {
-        location.reload()
+        skip.click()
+      } else if (e.key == "w") {
+        togglePlayback()
+      }
+    })
+  </script>
+</body>
+</html>
+`
+
+const multiView = `
+<!DOCTYPE html>
+<html>
+<head>
+<title>Media Rank</title>
+<style>
+  html {
+    font-family: "Open Sans", "Helvetica", "sans";
+  }
+  .link {
+    margin: 1em;
+    font-weight: bold;
+    text-decoration: none;
+  }
+  header {
+    text-align: center;
+    margin-bottom: 40px;
+  }
+  header form {
+    margin-top: 1em;
+  }
+  header input[type=number] {
+    width: 4em;
+  }
+  .selection {
+    display: grid;
+    grid-template-columns: repeat({{.Columns}}, 1fr);
+    grid-gap: 1em;
+  }
+  .candidate {
+    display: flex;
+    flex-direction: column;
+    align-items: center;
+    gap: 10px;
+  }
+  .image {
+    position: relative;
+    cursor: pointer;
+  }
+  img, video {
+    max-width: 100%;
+    max-height: 40vh;
+    border-radius: 4px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .place {
+    position: absolute;
+    top: 5px;
+    left: 5px;
+    padding: 2px 8px;
+    border-radius: 4px;
+    background: #0066cc;
+    color: white;
+    font-weight: bold;
+  }
+  .place:empty {
+    display: none;
+  }
+  form {
+    text-align: center;
+  }
+  input[type=submit] {
+    font-size: larger;
+  }
+  .info {
+    text-align: center;
+    margin-top: 2em;
+  }
+  audio {
+    width: 200px;
+  }
+  .audio {
+    display: flex;
+    flex-direction: column;
+    align-items: center;
+    gap: 10px;
+    padding: 2em;
+    border-radius: 4px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .audio .name {
+    font-weight: bold;
+    word-break: break-all;
+  }
+  .duration {
+    font-size: smaller;
+  }
+</style>
+</head>
+<body>
+  <header>
+    <h1>Media Rank</h1>
+    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a><a class="link" href="/history">History</a></div>
+    <form action="/multi" method="GET">
+      <label>Best of <input type="number" name="n" value="{{.N}}" min="3" max="9"></label>
+      <input type="submit" value="Change">
+    </form>
+  </header>
+  <div class="selection">
+    {{range $m := .Group}}
+    <div class="candidate">
+      <div class="image" data-id="{{$m.Id}}" title="Id: {{$m.Id}}, Score: {{$m.Score}}, Path: {{$m.Path}}">
+        {{template "media" $m.Full.Unlink}}
+        <span class="place"></span>
+      </div>
+      <form action="/multi/vote" method="POST">
+        <input type="hidden" name="ids" value="{{$.Ids}}">
+        <input type="hidden" name="winner" value="{{$m.Id}}">
+        <input type="submit" value="Best ({{$m.Key}})" class="winner">
+      </form>
+    </div>
+    {{end}}
+  </div>
+  <div class="info">
+    <p>Pick the best, or click every image from best to worst to rank them all.</p>
+    <form action="/multi/vote" method="POST">
+      <input type="hidden" name="ids" value="{{.Ids}}">
+      <input type="hidden" name="order" id="order">
+      <input type="submit" value="Submit Order (enter)" id="submitOrder" disabled>
+      <input type="button" value="Clear (c)" id="clearOrder">
+    </form>
+  </div>
+  {{template "player-script"}}
+  <script>
+    const winners = document.querySelectorAll('.winner');
+    const images = document.querySelectorAll('.image');
+    const order = document.getElementById('order');
+    const submitOrder = document.getElementById('submitOrder');
+    const clearOrder = document.getElementById('clearOrder');
+    let ranking = [];
+    const render = () => {
+      images.forEach((image) => {
+        const place = ranking.indexOf(image.dataset.id);
+        image.querySelector('.place').textContent = place < 0 ? '' : place + 1;
+      });
+      order.value = ranking.join(',');
+      submitOrder.disabled = ranking.length != images.length;
+    };
+    images.forEach((image) => {
+      image.addEventListener('click', () => {
+        if (!ranking.includes(image.dataset.id)) {
+          ranking.push(image.dataset.id);
+          render();
+        }
+      });
+    });
+    clearOrder.addEventListener('click', () => {
+      ranking = [];
+      render();
+    });
+    document.addEventListener('keypress', (e) => {
+      const n = parseInt(e.key);
+      if (n >= 1 && n <= winners.length) {
+        winners[n - 1].click()
+      } else if (e.key == "c") {
+        clearOrder.click()
+      } else if (e.key == "Enter" && !submitOrder.disabled) {
+        submitOrder.click()
       }
     })
   </script>
@@ -92,6 +334,70 @@ const indexView = `
 </html>
 `
 
+const placementView = `
+<!DOCTYPE html>
+<html>
+<head>
+<title>Media Rank</title>
+<style>
+  html {
+    font-family: "Open Sans", "Helvetica", "sans";
+  }
+  .link {
+    margin: 1em;
+    font-weight: bold;
+    text-decoration: none;
+  }
+  header {
+    text-align: center;
+    margin-bottom: 40px;
+  }
+  table {
+    margin: auto;
+    border-collapse: collapse;
+  }
+  td, th {
+    padding: 5px 15px;
+    text-align: center;
+  }
+  td img, td video {
+    max-height: 80px;
+    max-width: 80px;
+    border-radius: 3px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .empty {
+    text-align: center;
+  }
+  audio {
+    width: 200px;
+  }
+</style>
+</head>
+<body>
+  <header>
+    <h1>Placement Queue</h1>
+    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a><a class="link" href="/history">History</a></div>
+  </header>
+  {{if .Queue}}
+  <table>
+    <tr><th></th><th>Path</th><th>Votes</th><th>Votes Left</th></tr>
+    {{range .Queue}}
+    <tr>
+      <td>{{template "media" .Media.Tile}}</td>
+      <td>{{.Media.Path}}</td>
+      <td>{{.Steps}}</td>
+      <td>~{{.Remaining}}</td>
+    </tr>
+    {{end}}
+  </table>
+  {{else}}
+  <p class="empty">No media is waiting for placement.</p>
+  {{end}}
+</body>
+</html>
+`
+
 const listView = `
 <!DOCTYPE html>
 <html>
@@ -126,28 +432,60 @@ const listView = `
     align-items: center;
     flex-grow: 1;
   }
-  img {
+  img, video {
     height: 200px;
     width: 200px;
     border-radius: 3px;
     box-shadow: 0px 1px 2px #0000005e;
     object-fit: cover;
   }
+  header form {
+    margin-top: 1em;
+  }
+  header input[type=number] {
+    width: 5em;
+  }
+  .entry-score {
+    font-size: smaller;
+    margin-top: 3px;
+  }
+  .hard {
+    color: #b35c00;
+  }
   header {
     text-align: center;
     margin-bottom: 40px;
   }
+  audio {
+    width: 200px;
+  }
 </style>
 </head>
 <body>
   <header>
-    <h1>Media Rank</h1>
-    <div><a class="link" href="/">Face Off</a><a class="link" href="/history">History</a></div>
+    <h1>Media Rank{{if not .Scope.IsZero}}: {{.Scope}}{{end}}</h1>
+    <div><a class="link" href="/{{.ScopeQuery}}">Face Off</a><a class="link" href="/history{{.ScopeQuery}}">History</a><a class="link" href="/tournaments">Tournaments</a><a class="link" href="/brackets">Brackets</a><a class="link" href="/collections">Collections</a></div>
+    <form action="/recompute" method="POST">
+      <input type="submit" value="Recompute (Bradley-Terry)" title="Refit every score from the full comparison history">
+    </form>
+    <form action="/replay" method="POST">
+      {{if .K}}<label>K <input type="number" name="k" value="{{.K}}" min="1" step="any"></label>{{end}}
+      <label>Start <input type="number" name="start" value="1500"></label>
+      <input type="submit" value="Replay History" title="Reset every score and re-apply all comparisons in order">
+    </form>
+    <form action="/list" method="GET">
+      {{template "scope" .Scope}}
+      <input type="submit" value="Scope">
+      {{if .ScopeQuery}}<a href="/list">Everything</a>{{end}}
+    </form>
   </header>
   <div class="list">
   {{range $i, $e := .List}}
     <div class="list-entry">
-      <div class="entry-image"><a href="/media/{{$e.Id}}" target="_blank"><img title="Rank: {{$i}}, Score: {{$e.Score}}, File: {{.Path}}" src="/media/{{$e.Id}}" loading="lazy"></a></div>
+      {{$title := printf "Rank: %d, Score: %d" $i $e.Score}}{{if $.ShowConfidence}}{{$title = printf "%s ± %d" $title $e.Confidence}}{{end}}
+      <div class="entry-image">{{template "media" ($e.Tile.Titled (printf "%s, Matches: %d, Skips: %d, File: %s" $title $e.Matches $e.Skips $e.Path))}}</div>
+      {{if $.ShowConfidence}}<div class="entry-score">{{$e.Score}} ± {{$e.Confidence}}</div>{{end}}
+      {{if $e.HardToJudge}}<div class="entry-score hard" title="Skipped {{$e.Skips}} times">hard to judge</div>{{end}}
     </div>
   {{end}}
   </div>
@@ -176,7 +514,7 @@ const historyTmpl = `
   }
   .list {
     display: grid;
-    grid-template-columns: 1fr auto 1fr;
+    grid-template-columns: 1fr auto 1fr auto;
     max-width: fit-content;
     margin: auto;
   }
@@ -191,13 +529,24 @@ const historyTmpl = `
   .loser {
     justify-content: left;
   }
+  .draw {
+    color: #888;
+    font-style: italic;
+  }
+  .actions {
+    display: flex;
+    flex-direction: column;
+    justify-content: center;
+    gap: 5px;
+    margin-left: 20px;
+  }
   .image {
     display: flex;
     align-items: center;
     margin-bottom: 10px;
     min-height: 100px;
   }
-  img {
+  img, video {
     max-height: 200px;
     max-width: 200px;
     border-radius: 3px;
@@ -207,23 +556,639 @@ const historyTmpl = `
     text-align: center;
     margin-bottom: 40px;
   }
+  header form {
+    margin-top: 1em;
+  }
+  audio {
+    width: 200px;
+  }
 </style>
 </head>
 <body>
   <header>
-    <h1>Media Rank</h1>
-    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a></div>
+    <h1>Media Rank{{if not .Scope.IsZero}}: {{.Scope}}{{end}}</h1>
+    <div><a class="link" href="/{{.ScopeQuery}}">Face Off</a><a class="link" href="/list{{.ScopeQuery}}">Ranked List</a><a class="link" href="/tournaments">Tournaments</a><a class="link" href="/brackets">Brackets</a><a class="link" href="/collections">Collections</a></div>
+    <form action="/history" method="GET">
+      {{template "scope" .Scope}}
+      <input type="submit" value="Scope">
+      {{if .ScopeQuery}}<a href="/history">Everything</a>{{end}}
+    </form>
   </header>
   <div class="list">
   <span class="heading">Winner</span>
   <span class="heading">Points</span>
   <span class="heading">Loser</span>
+  <span class="heading"></span>
   {{range .Comparisons}}
-    <div class="winner image"><a href="/media/{{.Winner.Id}}" target="_blank"><img src="/media/{{.Winner.Id}}" title="{{.Winner.Path}}" loading="lazy"></a></div>
-    <div class="score">{{.Points}}</div>
-    <div class="loser image"><a href="/media/{{.Loser.Id}}" target="_blank"><img src="/media/{{.Loser.Id}}" title="{{.Loser.Path}}" loading="lazy"></a></div>
+    <div class="winner image">{{template "media" .Winner.Tile}}</div>
+    {{if .IsDraw}}<div class="score draw"{{if .WinnerK}} title="K: {{.WinnerK}} / {{.LoserK}}"{{end}}>Draw ({{.Points}})</div>{{else}}<div class="score"{{if .WinnerK}} title="K: {{.WinnerK}} / {{.LoserK}}"{{end}}>{{.Points}}</div>{{end}}
+    <div class="loser image">{{template "media" .Loser.Tile}}</div>
+    <div class="actions">
+      {{if not .IsDraw}}
+      <form action="/history/flip{{$.ScopeQuery}}" method="POST">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="submit" value="Flip Winner">
+      </form>
+      {{end}}
+      <form action="/history/delete{{$.ScopeQuery}}" method="POST" onsubmit="return confirm('Delete this comparison and recompute every score?')">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="submit" value="Delete">
+      </form>
+    </div>
+  {{end}}
+  </div>
+</body>
+</html>
+`
+
+
+const tournamentListView = `
+<!DOCTYPE html>
+<html>
+<head>
+<title>Media Rank</title>
+<style>
+  html {
+    font-family: "Open Sans", "Helvetica", "sans";
+  }
+  .link {
+    margin: 1em;
+    font-weight: bold;
+    text-decoration: none;
+  }
+  header {
+    text-align: center;
+    margin-bottom: 40px;
+  }
+  header form {
+    margin-top: 1em;
+  }
+  header input[type=number] {
+    width: 5em;
+  }
+  table {
+    margin: auto;
+    border-collapse: collapse;
+  }
+  td, th {
+    padding: 5px 15px;
+    text-align: left;
+  }
+  audio {
+    width: 200px;
+  }
+</style>
+</head>
+<body>
+  <header>
+    <h1>Media Rank</h1>
+    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a><a class="link" href="/history">History</a><a class="link" href="/brackets">Brackets</a></div>
+    <form action="/tournaments" method="POST">
+      <label>Name <input type="text" name="name" value="Tournament"></label>
+      <label>Rounds <input type="number" name="rounds" value="5" min="1"></label>
+      <label>Entrants <input type="number" name="size" value="32" min="0" title="0 enters up to 256 media"></label>
+      <input type="submit" value="Start Swiss Tournament">
+    </form>
+  </header>
+  <table>
+    <tr><th>Name</th><th>Entrants</th><th>Round</th><th></th></tr>
+    {{range .Tournaments}}
+    <tr>
+      <td><a href="/tournament/{{.Id}}">{{.Name}}</a></td>
+      <td>{{.Entrants}}</td>
+      <td>{{.Round}} / {{.Rounds}}</td>
+      <td>{{if .Finished}}finished{{end}}</td>
+    </tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`
+
+const collectionListView = `
+<!DOCTYPE html>
+<html>
+<head>
+<title>Media Rank</title>
+<style>
+  html {
+    font-family: "Open Sans", "Helvetica", "sans";
+  }
+  .link {
+    margin: 1em;
+    font-weight: bold;
+    text-decoration: none;
+  }
+  header {
+    text-align: center;
+    margin-bottom: 40px;
+  }
+  header form {
+    margin-top: 1em;
+  }
+  .scope {
+    display: inline;
+  }
+  table {
+    margin: auto;
+    border-collapse: collapse;
+  }
+  td, th {
+    padding: 5px 15px;
+    text-align: left;
+  }
+</style>
+</head>
+<body>
+  <header>
+    <h1>Media Rank</h1>
+    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a><a class="link" href="/history">History</a><a class="link" href="/tournaments">Tournaments</a><a class="link" href="/brackets">Brackets</a></div>
+    <form action="/collections" method="POST">
+      <label>Name <input type="text" name="name" required></label>
+      {{template "scope" .Scope}}
+      <input type="submit" value="Create Collection">
+    </form>
+  </header>
+  <table>
+    <tr><th>Name</th><th>Media</th><th>Scope</th><th></th></tr>
+    {{range .Collections}}
+    <tr>
+      <td>{{.Name}}</td>
+      <td>{{.Size}}</td>
+      <td>{{.Scope}}</td>
+      <td><a href="/?collection={{.Id}}">Face Off</a> <a href="/list?collection={{.Id}}">Ranked List</a> <a href="/history?collection={{.Id}}">History</a></td>
+    </tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`
+
+const tournamentView = `
+<!DOCTYPE html>
+<html>
+<head>
+<title>Media Rank</title>
+<style>
+  html {
+    font-family: "Open Sans", "Helvetica", "sans";
+  }
+  .link {
+    margin: 1em;
+    font-weight: bold;
+    text-decoration: none;
+  }
+  header {
+    text-align: center;
+    margin-bottom: 40px;
+  }
+  .selection {
+    display: grid;
+    grid-template-columns: 1fr 1fr;
+    grid-gap: 1em;
+    margin-bottom: 40px;
+  }
+  .selection img, .selection video {
+    max-width: 100%;
+    max-height: 70vh;
+  }
+  img, video {
+    border-radius: 4px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .image {
+    display: flex;
+    align-self: center;
+    justify-content: center;
+  }
+  form {
+    text-align: center;
+  }
+  input[type=submit] {
+    font-size: larger;
+  }
+  form.draw {
+    grid-column: 1 / span 2;
+  }
+  table {
+    margin: auto;
+    border-collapse: collapse;
+  }
+  td, th {
+    padding: 5px 15px;
+    text-align: center;
+  }
+  td img, td video {
+    max-height: 80px;
+    max-width: 80px;
+  }
+  .audio {
+    display: flex;
+    flex-direction: column;
+    align-items: center;
+    gap: 10px;
+    padding: 2em;
+    border-radius: 4px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .audio .name {
+    font-weight: bold;
+    word-break: break-all;
+  }
+  .duration {
+    font-size: smaller;
+  }
+</style>
+</head>
+<body>
+  <header>
+    <h1>{{.Tournament.Name}}</h1>
+    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a><a class="link" href="/tournaments">Tournaments</a><a class="link" href="/brackets">Brackets</a></div>
+    <div>{{if .Tournament.Finished}}Finished after round {{.Tournament.Round}}{{else}}Round {{.Tournament.Round}} of {{.Tournament.Rounds}}{{end}}</div>
+  </header>
+  {{if .Match.Id}}
+  <div class="selection">
+    <div class="image">
+      {{template "media" .Match.Media1.Full}}
+    </div>
+    <div class="image">
+      {{template "media" .Match.Media2.Full}}
+    </div>
+    <form action="/tournament/vote" method="POST">
+      <input type="hidden" name="tournament" value="{{.Tournament.Id}}">
+      <input type="hidden" name="match" value="{{.Match.Id}}">
+      <input type="hidden" name="winner" value="{{.Match.Media1.Id}}">
+      <input type="submit" value="Winner (a)" id="winnerLeft">
+    </form>
+    <form action="/tournament/vote" method="POST">
+      <input type="hidden" name="tournament" value="{{.Tournament.Id}}">
+      <input type="hidden" name="match" value="{{.Match.Id}}">
+      <input type="hidden" name="winner" value="{{.Match.Media2.Id}}">
+      <input type="submit" value="Winner (d)" id="winnerRight">
+    </form>
+    <form action="/tournament/vote" method="POST" class="draw">
+      <input type="hidden" name="tournament" value="{{.Tournament.Id}}">
+      <input type="hidden" name="match" value="{{.Match.Id}}">
+      <input type="hidden" name="winner" value="{{.Match.Media1.Id}}">
+      <input type="hidden" name="outcome" value="draw">
+      <input type="submit" value="Draw (s)" id="draw">
+    </form>
+  </div>
+  {{template "player-script"}}
+  <script>
+    const winnerLeft = document.getElementById('winnerLeft');
+    const winnerRight = document.getElementById('winnerRight');
+    const draw = document.getElementById('draw');
+    document.addEventListener('keypress', (e) => {
+      if (e.key == "a") {
+        winnerLeft.click()
+      } else if (e.key == "d") {
+        winnerRight.click()
+      } else if (e.key == "s") {
+        draw.click()
+      } else if (e.key == "w") {
+        togglePlayback()
+      }
+    })
+  </script>
+  {{end}}
+  <table>
+    <tr><th>#</th><th></th><th>Points</th><th>W / D / L</th><th>Byes</th><th>Buchholz</th></tr>
+    {{range $i, $s := .Standings}}
+    <tr>
+      <td>{{$i}}</td>
+      <td>{{template "media" $s.Tile}}</td>
+      <td>{{$s.Points}}</td>
+      <td>{{$s.Wins}} / {{$s.Draws}} / {{$s.Losses}}</td>
+      <td>{{$s.Byes}}</td>
+      <td>{{$s.Buchholz}}</td>
+    </tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`
+
+
+const bracketListView = `
+<!DOCTYPE html>
+<html>
+<head>
+<title>Media Rank</title>
+<style>
+  html {
+    font-family: "Open Sans", "Helvetica", "sans";
+  }
+  .link {
+    margin: 1em;
+    font-weight: bold;
+    text-decoration: none;
+  }
+  header {
+    text-align: center;
+    margin-bottom: 40px;
+  }
+  header form {
+    margin-top: 1em;
+  }
+  header input[type=number] {
+    width: 5em;
+  }
+  table {
+    margin: auto;
+    border-collapse: collapse;
+  }
+  td, th {
+    padding: 5px 15px;
+    text-align: left;
+  }
+  td img, td video {
+    max-height: 60px;
+    max-width: 60px;
+    border-radius: 3px;
+  }
+  audio {
+    width: 200px;
+  }
+</style>
+</head>
+<body>
+  <header>
+    <h1>Media Rank</h1>
+    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a><a class="link" href="/history">History</a><a class="link" href="/tournaments">Tournaments</a></div>
+    <form action="/brackets" method="POST">
+      <label>Name <input type="text" name="name" value="Bracket"></label>
+      <select name="elimination">
+        <option value="single">Single Elimination</option>
+        <option value="double">Double Elimination</option>
+      </select>
+      <label>Entrants <input type="number" name="size" value="16" min="2"></label>
+      <select name="pool">
+        <option value="top">Highest Ranked</option>
+        <option value="random">Random</option>
+      </select>
+      <label>or Ids <input type="text" name="ids" placeholder="1, 5, 12" title="Comma separated media ids, overrides entrants"></label>
+      <select name="seeding">
+        <option value="score">Seed by Score</option>
+        <option value="random">Seed at Random</option>
+      </select>
+      <input type="submit" value="Start Bracket">
+    </form>
+  </header>
+  <table>
+    <tr><th>Name</th><th>Entrants</th><th>Champion</th></tr>
+    {{range .Brackets}}
+    <tr>
+      <td><a href="/bracket/{{.Id}}">{{.Name}}</a> {{if .Double}}(double){{end}}</td>
+      <td>{{.Entrants}}</td>
+      <td>{{if .Finished}}{{template "media" .Champion.Tile}}{{end}}</td>
+    </tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`
+
+const bracketView = `
+<!DOCTYPE html>
+<html>
+<head>
+<title>Media Rank</title>
+<style>
+  html {
+    font-family: "Open Sans", "Helvetica", "sans";
+  }
+  .link {
+    margin: 1em;
+    font-weight: bold;
+    text-decoration: none;
+  }
+  header {
+    text-align: center;
+    margin-bottom: 40px;
+  }
+  .selection {
+    display: grid;
+    grid-template-columns: 1fr 1fr;
+    grid-gap: 1em;
+    margin-bottom: 40px;
+  }
+  .selection img, .selection video {
+    max-width: 100%;
+    max-height: 70vh;
+  }
+  img, video {
+    border-radius: 4px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .image {
+    display: flex;
+    align-self: center;
+    justify-content: center;
+  }
+  form {
+    text-align: center;
+  }
+  input[type=submit] {
+    font-size: larger;
+  }
+  h2 {
+    text-align: center;
+  }
+  .champion img, .champion video {
+    max-height: 50vh;
+    max-width: 100%;
+  }
+  .bracket {
+    display: flex;
+    justify-content: center;
+    gap: 30px;
+    overflow-x: auto;
+  }
+  .round {
+    display: flex;
+    flex-direction: column;
+    justify-content: space-around;
+    gap: 10px;
+  }
+  .match {
+    display: flex;
+    flex-direction: column;
+    gap: 3px;
+    padding: 5px;
+    border: 1px solid #ccc;
+    border-radius: 4px;
+  }
+  .match.current {
+    border-color: #0066cc;
+    border-width: 2px;
+  }
+  .match img, .match video {
+    height: 60px;
+    width: 60px;
+    object-fit: cover;
+  }
+  .match .loser {
+    opacity: 0.3;
+  }
+  .slot {
+    display: flex;
+    align-items: center;
+    justify-content: center;
+    height: 60px;
+    width: 60px;
+    color: #888;
+    font-size: smaller;
+  }
+  .audio {
+    display: flex;
+    flex-direction: column;
+    align-items: center;
+    gap: 10px;
+    padding: 2em;
+    border-radius: 4px;
+    box-shadow: 0px 1px 2px #0000005e;
+  }
+  .audio .name {
+    font-weight: bold;
+    word-break: break-all;
+  }
+  .duration {
+    font-size: smaller;
+  }
+</style>
+</head>
+<body>
+  <header>
+    <h1>{{.Bracket.Name}}</h1>
+    <div><a class="link" href="/">Face Off</a><a class="link" href="/list">Ranked List</a><a class="link" href="/brackets">Brackets</a></div>
+  </header>
+  {{if .Playing}}
+  <div class="selection">
+    <div class="image">
+      {{template "media" .Next.Media1.Full}}
+    </div>
+    <div class="image">
+      {{template "media" .Next.Media2.Full}}
+    </div>
+    <form action="/bracket/vote" method="POST">
+      <input type="hidden" name="bracket" value="{{.Bracket.Id}}">
+      <input type="hidden" name="match" value="{{.Next.Number}}">
+      <input type="hidden" name="winner" value="{{.Next.Media1.Id}}">
+      <input type="submit" value="Winner (a)" id="winnerLeft">
+    </form>
+    <form action="/bracket/vote" method="POST">
+      <input type="hidden" name="bracket" value="{{.Bracket.Id}}">
+      <input type="hidden" name="match" value="{{.Next.Number}}">
+      <input type="hidden" name="winner" value="{{.Next.Media2.Id}}">
+      <input type="submit" value="Winner (d)" id="winnerRight">
+    </form>
+  </div>
+  {{template "player-script"}}
+  <script>
+    const winnerLeft = document.getElementById('winnerLeft');
+    const winnerRight = document.getElementById('winnerRight');
+    document.addEventListener('keypress', (e) => {
+      if (e.key == "a") {
+        winnerLeft.click()
+      } else if (e.key == "d") {
+        winnerRight.click()
+      } else if (e.key == "w") {
+        togglePlayback()
+      }
+    })
+  </script>
+  {{else if .Bracket.Finished}}
+  <h2>Champion</h2>
+  <div class="image champion">
+    {{template "media" .Bracket.Champion.Full}}
+  </div>
   {{end}}
+  {{range .Bracket.Sections}}
+  <h2>{{.Name}}</h2>
+  <div class="bracket">
+    {{range .Rounds}}
+    <div class="round">
+      {{range .}}
+      <div class="match{{if and $.Playing (eq .Number $.Next.Number)}} current{{end}}">
+        {{if .Media1.Id}}{{template "media" (.Media1.Tile.Lost (and .Decided (ne .WinnerId .Media1.Id)))}}{{else if .Bye1}}<span class="slot">bye</span>{{else}}<span class="slot">TBD</span>{{end}}
+        {{if .Media2.Id}}{{template "media" (.Media2.Tile.Lost (and .Decided (ne .WinnerId .Media2.Id)))}}{{else if .Bye2}}<span class="slot">bye</span>{{else}}<span class="slot">TBD</span>{{end}}
+      </div>
+      {{end}}
+    </div>
+    {{end}}
   </div>
+  {{end}}
 </body>
 </html>
 `
+
+// scopeView is parsed along with the views that can be scoped, it
+// renders the inputs of a Scope
+const scopeView = `
+{{define "scope"}}
+<div class="scope">
+  <label>Folder <input type="text" name="prefix" value="{{.Prefix}}" placeholder="photos/2023/"></label>
+  <label>Glob <input type="text" name="glob" value="{{.Glob}}" placeholder="*.png"></label>
+  <label>Tag <input type="text" name="tag" value="{{.Tag}}" size="10"></label>
+  {{if .Collection}}<input type="hidden" name="collection" value="{{.Collection}}">{{end}}
+</div>
+{{end}}
+`
+
+// mediaView is parsed along with the views that show media. "media"
+// renders a MediaView as a video, audio player or image. The #t=0.1
+// media fragment has the browser show a full size video's first frames
+// instead of a blank box. Tiles show images by their thumbnails and
+// don't preload videos, a page of them would otherwise fetch and seek
+// every video on it; their poster is a frame cached with the thumbnails
+// when ffmpeg is installed to make one. "player-script" fills in audio
+// durations and defines togglePlayback, which switches playback between
+// the first two players on the page.
+const mediaView = `
+{{define "media"}}
+{{- if .IsVideo -}}
+<video src="/media/{{.Id}}#t=0.1"{{with .Title}} title="{{.}}"{{end}}{{if .Loser}} class="loser"{{end}}{{if .Tiled}}{{if .HasPoster}} poster="/thumb/{{.Id}}"{{end}} preload="none"{{else}} preload="metadata"{{end}} controls loop></video>
+{{- else if .IsAudio -}}
+{{if .Tiled -}}
+<audio src="/media/{{.Id}}"{{with .Title}} title="{{.}}"{{end}}{{if .Loser}} class="loser"{{end}} preload="none" controls></audio>
+{{- else -}}
+<div class="audio"{{with .Title}} title="{{.}}"{{end}}>
+  <div class="name">{{.Path}}</div>
+  <audio src="/media/{{.Id}}" preload="metadata" controls></audio>
+  <div class="duration"></div>
+</div>
+{{- end}}
+{{- else -}}
+{{if not .Unlinked}}<a href="/media/{{.Id}}" target="_blank">{{end -}}
+<img src="/{{if .Tiled}}thumb{{else}}media{{end}}/{{.Id}}"{{with .Title}} title="{{.}}"{{end}}{{if .Tiled}} loading="lazy"{{end}}{{if .Loser}} class="loser"{{end}}>
+{{- if not .Unlinked}}</a>{{end}}
+{{- end -}}
+{{end}}
+
+{{define "player-script"}}
+<script>
+  const players = document.querySelectorAll('.image audio, .image video');
+  const showDuration = (audio) => {
+    const seconds = Math.round(audio.duration);
+    audio.parentElement.querySelector('.duration').textContent = Math.floor(seconds / 60) + ':' + String(seconds % 60).padStart(2, '0');
+  };
+  document.querySelectorAll('.audio audio').forEach((audio) => {
+    if (audio.readyState > 0) {
+      showDuration(audio)
+    } else {
+      audio.addEventListener('loadedmetadata', () => showDuration(audio))
+    }
+  })
+  // switches playback between the left and right media
+  const togglePlayback = () => {
+    if (players.length < 2) {
+      return
+    }
+    const [playing, next] = players[0].paused ? [players[1], players[0]] : [players[0], players[1]];
+    playing.pause()
+    next.play()
+  };
+</script>
+{{end}}
+`